@@ -68,7 +68,9 @@ func Authorization(hdlr http.Handler) http.Handler {
 			_, err := io.Copy(w, req.Body)
 			if err != nil {
 				rw.WriteHeader(http.StatusBadRequest)
+				return
 			}
+			req.Body = io.NopCloser(b)
 		}
 
 		subject := enc(h.Sum(nil))
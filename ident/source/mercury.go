@@ -69,6 +69,10 @@ func (id *mercuryIdent) ToConfig() mercury.Config {
 			Values: values,
 		}
 	}
+	secret := func(v mercury.Value) mercury.Value {
+		v.Tags = append(v.Tags, mercury.SecretTag)
+		return v
+	}
 	return mercury.Config{
 		&mercury.Space{
 			Space: space,
@@ -80,7 +84,7 @@ func (id *mercuryIdent) ToConfig() mercury.Config {
 		&mercury.Space{
 			Space: space + identSFX,
 			List: list(
-				value(space+identSFX, 1, "passwd", string(id.passwd)),
+				secret(value(space+identSFX, 1, "passwd", string(id.passwd))),
 				value(space+identSFX, 1, "ed25519", string(id.ed25519)),
 			),
 		},
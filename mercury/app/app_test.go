@@ -3,10 +3,13 @@ package app
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 
-	"go.sour.is/pkg/mercury"
+	"github.com/matryer/is"
 	"go.sour.is/pkg/ident"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
 )
 
 type mockUser struct {
@@ -78,6 +81,82 @@ func Test_appConfig_GetRules(t *testing.T) {
 	}
 }
 
+func Test_appConfig_GetConfigFields(t *testing.T) {
+	is := is.New(t)
+
+	a := mercuryEnviron{}
+	lis, err := a.GetConfig(context.TODO(), mercury.ParseSearch("mercury.host fields uid,gid"))
+	is.NoErr(err)
+	is.Equal(len(lis), 1)
+	is.Equal(len(lis[0].List), 2)
+	is.Equal(lis[0].List[0].Name, "uid")
+	is.Equal(lis[0].List[1].Name, "gid")
+}
+
+func Test_registry_mem(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	seed, err := mercury.ParseText(strings.NewReader(`
+@mercury.groups
+admins :user
+
+@mercury.policy
+admins :read NS app.*
+       :write NS app.one
+
+@mercury.notify
+app    :app.* updated POST http://example.com/hook
+
+@app.one
+host :db.prod
+port :5432
+
+@app.two
+host :db.local
+`))
+	is.NoErr(err)
+
+	h := mem.New(seed)
+	mercury.Registry.Register("test-mem", func(s *mercury.Space) any { return h })
+
+	src := mercury.NewSpace("mercury.source.test-mem.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	rules, err := mercury.Registry.GetRules(ctx, &mockUser{})
+	is.NoErr(err)
+	is.Equal(rules, mercury.Rules{
+		{Role: "read", Type: "NS", Match: "app.*"},
+		{Role: "write", Type: "NS", Match: "app.one"},
+	})
+
+	lis, err := mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.* find host=eq=db.prod fields port"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), "app.one:port=5432\n")
+
+	s := mercury.NewSpace("app.one").AddKeys(mercury.NewValue("host").SetValues("db.next"))
+	is.NoErr(mercury.Registry.WriteConfig(ctx, mercury.Config{s}))
+
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.one"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), "app.one:host=db.next\n")
+
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.one at 5"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), "app.one:host=db.prod\napp.one:port=5432\n")
+
+	notify, err := mercury.Registry.GetNotify(ctx, "updated")
+	is.NoErr(err)
+	notify = notify.Find("app.one")
+	is.Equal(len(notify), 1)
+	is.NoErr(mercury.Registry.SendNotify(ctx, notify[0]))
+	is.Equal(h.Sent(), mercury.ListNotify{
+		{Name: "app", Match: "app.*", Event: "updated", Method: "POST", URL: "http://example.com/hook"},
+	})
+}
+
 // func Test_appConfig_GetIndex(t *testing.T) {
 // 	type args struct {
 // 		search mercury.NamespaceSearch
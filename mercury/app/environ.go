@@ -233,6 +233,12 @@ func (app *mercuryEnviron) GetConfig(ctx context.Context, spec mercury.Search) (
 		}
 	}
 
+	if len(spec.Fields) > 0 {
+		for i, s := range lis {
+			lis[i] = s.Project(spec.Fields...)
+		}
+	}
+
 	return
 }
 
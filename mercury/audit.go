@@ -0,0 +1,241 @@
+package mercury
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"go.sour.is/pkg/ident"
+	"go.sour.is/pkg/lg"
+)
+
+// Audit actions.
+const (
+	AuditRead     = "read"
+	AuditReveal   = "reveal" // a read that returned secret values
+	AuditWrite    = "write"
+	AuditDryRun   = "dry_run"
+	AuditRollback = "rollback"
+	AuditDiff     = "diff"
+	AuditWatch    = "watch"
+)
+
+// Audit outcomes.
+const (
+	AuditOK     = "ok"
+	AuditDenied = "denied"
+	AuditError  = "error"
+)
+
+// Audit records a mercury operation by a user.
+type Audit struct {
+	Time     time.Time `json:"time"`
+	Identity string    `json:"identity"`
+	Session  string    `json:"session"`
+	Action   string    `json:"action"`
+	Spaces   []string  `json:"spaces"`
+	Outcome  string    `json:"outcome"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+// String formats the record as a line of space separated fields.
+func (a Audit) String() string {
+	return strings.Join([]string{
+		a.Time.Format(time.RFC3339Nano),
+		a.Identity,
+		cmp.Or(a.Session, "-"),
+		a.Action,
+		a.Outcome,
+		strings.Join(a.Spaces, ","),
+		cmp.Or(a.Hash, "-"),
+	}, " ")
+}
+
+// NewAudit returns an audit of the action on the spaces by the user in ctx.
+func NewAudit(ctx context.Context, action, outcome string, spaces []string, hash string) Audit {
+	id := ident.FromContext(ctx)
+
+	a := Audit{
+		Time:     time.Now().UTC(),
+		Identity: id.Identity(),
+		Action:   action,
+		Spaces:   spaces,
+		Outcome:  outcome,
+		Hash:     hash,
+	}
+	if s := id.Session(); s != nil && s.Active && s.SessionID != (ulid.ULID{}) {
+		a.Session = s.SessionID.String()
+	}
+
+	return a
+}
+
+// audit records the action on the spaces. The hash is of the spaces as the
+// user saw or wrote them, so it matches the ETag of a read. Sink errors are
+// logged and do not fail the request.
+func audit(ctx context.Context, action, outcome string, lis Config) {
+	if len(lis) == 0 {
+		return
+	}
+	auditSpaces(ctx, action, outcome, lis.Hash(), spaceNames(lis)...)
+}
+
+// auditSpaces records the action on spaces by name.
+func auditSpaces(ctx context.Context, action, outcome, hash string, spaces ...string) {
+	if len(spaces) == 0 {
+		return
+	}
+	err := Registry.WriteAudit(ctx, NewAudit(ctx, action, outcome, spaces, hash))
+	if err != nil {
+		log.Println("audit:", err)
+	}
+}
+
+// auditDenied records the spaces in all that were filtered from allowed.
+func auditDenied(ctx context.Context, action string, all, allowed Config) {
+	seen := make(map[string]struct{}, len(allowed))
+	for _, s := range allowed {
+		seen[s.Space] = struct{}{}
+	}
+	var denied []string
+	for _, s := range all {
+		if _, ok := seen[s.Space]; !ok {
+			seen[s.Space] = struct{}{}
+			denied = append(denied, s.Space)
+		}
+	}
+	auditSpaces(ctx, action, AuditDenied, "", denied...)
+}
+
+// spaceNames returns the unique space names in order.
+func spaceNames(lis Config) []string {
+	seen := make(map[string]struct{}, len(lis))
+	names := make([]string, 0, len(lis))
+	for _, s := range lis {
+		if _, ok := seen[s.Space]; !ok {
+			seen[s.Space] = struct{}{}
+			names = append(names, s.Space)
+		}
+	}
+	return names
+}
+
+// revealed returns true if the spaces hold secret values the rules reveal.
+func revealed(rules Rules, lis Config) bool {
+	for _, s := range lis {
+		if hasSecrets(s) && rules.GetRoles("NS", s.Space).HasRole("reveal") {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditSearch selects audit records. Empty fields match every record.
+type AuditSearch struct {
+	Spaces   NamespaceSearch
+	Identity string
+	Action   string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Match returns true if the record is selected by the search.
+func (s AuditSearch) Match(a Audit) bool {
+	if s.Identity != "" && s.Identity != a.Identity {
+		return false
+	}
+	if s.Action != "" && s.Action != a.Action {
+		return false
+	}
+	if !s.Since.IsZero() && a.Time.Before(s.Since) {
+		return false
+	}
+	if !s.Until.IsZero() && !a.Time.Before(s.Until) {
+		return false
+	}
+	if len(s.Spaces) == 0 {
+		return true
+	}
+	for _, space := range a.Spaces {
+		if s.Spaces.Match(space) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteAudit is implemented by audit sinks.
+type WriteAudit interface {
+	WriteAudit(context.Context, ...Audit) error
+}
+
+// ReadAudit is implemented by audit sinks that can be queried. Records are
+// returned newest first.
+type ReadAudit interface {
+	ReadAudit(context.Context, AuditSearch) ([]Audit, error)
+}
+
+// WriteAudit sends the records to each audit sink that matches one of their
+// spaces. Records without spaces are sent to every sink.
+func (r *registry) WriteAudit(ctx context.Context, lis ...Audit) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	ms, done := r.acquire()
+	defer done()
+
+	var errs error
+	for _, hdlr := range ms.writeAudit {
+		var match []Audit
+		for _, a := range lis {
+			if len(a.Spaces) == 0 || (AuditSearch{Spaces: hdlr.Match.NamespaceSearch}).Match(a) {
+				match = append(match, a)
+			}
+		}
+		if len(match) == 0 {
+			continue
+		}
+
+		span.AddEvent(fmt.Sprint("WRITE AUDIT", hdlr.Name, hdlr.Match))
+		if err := hdlr.Handler.WriteAudit(ctx, match...); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("audit %s: %w", hdlr.Name, err))
+		}
+	}
+	span.RecordError(errs)
+
+	return errs
+}
+
+// ReadAudit queries each audit sink and returns the records newest first up
+// to the search limit.
+func (r *registry) ReadAudit(ctx context.Context, search AuditSearch) ([]Audit, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	ms, done := r.acquire()
+	defer done()
+
+	var lis []Audit
+	for _, hdlr := range ms.readAudit {
+		span.AddEvent(fmt.Sprint("READ AUDIT", hdlr.Name, hdlr.Match))
+		found, err := hdlr.Handler.ReadAudit(ctx, search)
+		if err != nil {
+			return nil, err
+		}
+		lis = append(lis, found...)
+	}
+
+	sort.SliceStable(lis, func(i, j int) bool { return lis[i].Time.After(lis[j].Time) })
+	if search.Limit > 0 && len(lis) > search.Limit {
+		lis = lis[:search.Limit]
+	}
+
+	return lis, nil
+}
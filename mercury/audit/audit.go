@@ -0,0 +1,117 @@
+// Package audit provides file sinks for the mercury audit log.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/mercury"
+)
+
+// Register adds the audit sinks to the registry. They are configured with:
+//
+//	@mercury.output.audit-jsonl.<name>
+//	match :<priority> <search>
+//	path  :<file of JSON lines>
+//
+//	@mercury.output.audit-lsm.<name>
+//	match :<priority> <search>
+//	path  :<lsm log file>
+func Register() {
+	mercury.Registry.Register("audit-jsonl", func(s *mercury.Space) any {
+		path := s.FirstValue("path").First()
+		if path == "" {
+			return fmt.Errorf("audit-jsonl: missing path")
+		}
+		return &jsonlSink{path: path}
+	})
+	mercury.Registry.Register("audit-lsm", func(s *mercury.Space) any {
+		path := s.FirstValue("path").First()
+		if path == "" {
+			return fmt.Errorf("audit-lsm: missing path")
+		}
+		return &lsmSink{path: path}
+	})
+}
+
+// jsonlSink appends each audit entry as a line of JSON.
+type jsonlSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+var (
+	_ mercury.WriteAudit = (*jsonlSink)(nil)
+	_ mercury.ReadAudit  = (*jsonlSink)(nil)
+)
+
+func (h *jsonlSink) WriteAudit(ctx context.Context, lis ...mercury.Audit) error {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, a := range lis {
+		if err = enc.Encode(a); err != nil {
+			break
+		}
+	}
+	err = errors.Join(err, f.Close())
+	span.RecordError(err)
+
+	return err
+}
+
+func (h *jsonlSink) ReadAudit(ctx context.Context, search mercury.AuditSearch) ([]mercury.Audit, error) {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lis []mercury.Audit
+	scan := bufio.NewScanner(f)
+	scan.Buffer(nil, 1<<20)
+	for line := 1; scan.Scan(); line++ {
+		var a mercury.Audit
+		if err := json.Unmarshal(scan.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("audit-jsonl: line %d: %w", line, err)
+		}
+		if search.Match(a) {
+			lis = append(lis, a)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(lis)
+	if search.Limit > 0 && len(lis) > search.Limit {
+		lis = lis[:search.Limit]
+	}
+
+	return lis, nil
+}
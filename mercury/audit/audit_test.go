@@ -0,0 +1,69 @@
+package audit_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/audit"
+)
+
+func TestSinks(t *testing.T) {
+	audit.Register()
+
+	for _, handler := range []string{"audit-jsonl", "audit-lsm"} {
+		t.Run(handler, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+
+			out := mercury.NewSpace("mercury.output." + handler + ".test")
+			out.AddKeys(
+				mercury.NewValue("match").SetValues("1 app.*"),
+				mercury.NewValue("path").SetValues(filepath.Join(t.TempDir(), "audit.log")),
+			)
+			is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{out.Space: out}))
+			defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+			// an empty log reads as no entries.
+			lis, err := mercury.Registry.ReadAudit(ctx, mercury.AuditSearch{})
+			is.NoErr(err)
+			is.Equal(len(lis), 0)
+
+			now := time.Now().UTC().Truncate(time.Millisecond)
+			is.NoErr(mercury.Registry.WriteAudit(ctx,
+				mercury.Audit{Time: now.Add(-2 * time.Minute), Identity: "a", Action: "read", Spaces: []string{"app.one"}, Outcome: "ok", Hash: "h1"},
+				mercury.Audit{Time: now.Add(-time.Minute), Identity: "b", Action: "write", Spaces: []string{"app.two"}, Outcome: "denied"},
+				mercury.Audit{Time: now, Identity: "a", Action: "read", Spaces: []string{"db.one"}, Outcome: "ok"},
+			))
+			is.NoErr(mercury.Registry.WriteAudit(ctx,
+				mercury.Audit{Time: now, Identity: "c", Action: "reveal", Spaces: []string{"app.one"}, Outcome: "ok", Hash: "h2"},
+			))
+
+			// db.one is not matched by the sink.
+			lis, err = mercury.Registry.ReadAudit(ctx, mercury.AuditSearch{})
+			is.NoErr(err)
+			is.Equal(len(lis), 3)
+			is.Equal(lis[0].Identity, "c")
+			is.Equal(lis[2].Hash, "h1")
+			is.Equal(lis[2].Time, now.Add(-2*time.Minute))
+
+			lis, err = mercury.Registry.ReadAudit(ctx, mercury.AuditSearch{Identity: "a"})
+			is.NoErr(err)
+			is.Equal(len(lis), 1)
+			is.Equal(lis[0].Spaces, []string{"app.one"})
+
+			lis, err = mercury.Registry.ReadAudit(ctx, mercury.AuditSearch{Spaces: mercury.ParseSearch("app.two").NamespaceSearch})
+			is.NoErr(err)
+			is.Equal(len(lis), 1)
+			is.Equal(lis[0].Outcome, "denied")
+
+			lis, err = mercury.Registry.ReadAudit(ctx, mercury.AuditSearch{Since: now.Add(-90 * time.Second), Limit: 1})
+			is.NoErr(err)
+			is.Equal(len(lis), 1)
+			is.Equal(lis[0].Action, "reveal")
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/lsm"
+	"go.sour.is/pkg/mercury"
+)
+
+// lsmSink writes each audit entry as a JSON segment of an lsm log file. Each
+// call to WriteAudit is a single commit.
+type lsmSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+var (
+	_ mercury.WriteAudit = (*lsmSink)(nil)
+	_ mercury.ReadAudit  = (*lsmSink)(nil)
+)
+
+func (h *lsmSink) WriteAudit(ctx context.Context, lis ...mercury.Audit) error {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	segments := make([]io.Reader, len(lis))
+	for i, a := range lis {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		segments[i] = bytes.NewReader(b)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err == nil {
+		if fi.Size() == 0 {
+			err = lsm.WriteLogFile(f, slices.Values(segments))
+		} else {
+			err = lsm.AppendLogFile(f, slices.Values(segments))
+		}
+	}
+	err = errors.Join(err, f.Close())
+	span.RecordError(err)
+
+	return err
+}
+
+func (h *lsmSink) ReadAudit(ctx context.Context, search mercury.AuditSearch) ([]mercury.Audit, error) {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lr, err := lsm.ReadLogFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var lis []mercury.Audit
+	for _, r := range lr.Iter(0) {
+		var a mercury.Audit
+		if err := json.NewDecoder(r).Decode(&a); err != nil {
+			return nil, err
+		}
+		if search.Match(a) {
+			lis = append(lis, a)
+		}
+	}
+	if lr.Err != nil {
+		return nil, lr.Err
+	}
+
+	slices.Reverse(lis)
+	if search.Limit > 0 && len(lis) > search.Limit {
+		lis = lis[:search.Limit]
+	}
+
+	return lis, nil
+}
@@ -0,0 +1,116 @@
+package mercury
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+)
+
+// cursor records the position reached in each matched source for a paged
+// search. It is keyed by source and a source with no more results is -1.
+//
+// Pages are cut in byte order of the space names, the order of sort.Sort on
+// a Config. Sources that honor Count and Offset must return spaces in that
+// order and implement Paged. The sql source orders by space, which is byte
+// order in SQLite and in PostgreSQL with the C collation.
+type cursor map[string]int64
+
+const cursorDone int64 = -1
+
+// decodeCursor reads an opaque cursor. An invalid cursor starts from the beginning.
+func decodeCursor(s string) cursor {
+	c := make(cursor)
+	if s == "" {
+		return c
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c
+	}
+	_ = json.Unmarshal(b, &c)
+
+	return c
+}
+
+// String encodes the cursor. An empty string is returned when every source is done.
+func (c cursor) String() string {
+	done := true
+	for _, pos := range c {
+		if pos != cursorDone {
+			done = false
+		}
+	}
+	if done {
+		return ""
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// nextPage trims the merged results to a single page of search.Count spaces
+// and returns the cursor that continues after it. Each source is advanced by
+// the number of its results that sort before the end of the page.
+func nextPage(search Search, keys []string, results []Config, lis Config) (Config, string) {
+	if search.Count == 0 {
+		return lis, ""
+	}
+
+	sort.Sort(lis)
+	if uint64(len(lis)) > search.Count {
+		lis = lis[:search.Count]
+	}
+
+	var last string
+	if len(lis) > 0 {
+		last = lis[len(lis)-1].Space
+	}
+
+	cur := decodeCursor(search.Cursor)
+	next := make(cursor, len(keys))
+	for i, key := range keys {
+		if cur[key] == cursorDone || results[i] == nil {
+			next[key] = cursorDone
+			continue
+		}
+
+		var consumed int64
+		for _, s := range results[i] {
+			if s.Space <= last {
+				consumed++
+			}
+		}
+
+		if uint64(len(results[i])) < search.Count && consumed == int64(len(results[i])) {
+			next[key] = cursorDone
+			continue
+		}
+		next[key] = cur[key] + consumed
+	}
+
+	return lis, next.String()
+}
+
+// pageResult applies the cursor position to results from a source that does
+// not page on its own. Results of a source that implements Paged are left
+// as is.
+func pageResult(search Search, source any, lis Config) Config {
+	if search.Count == 0 {
+		return lis
+	}
+	if p, ok := source.(Paged); ok && p.Paged() {
+		return lis
+	}
+
+	sort.Sort(lis)
+	if search.Offset >= uint64(len(lis)) {
+		return Config{}
+	}
+	lis = lis[search.Offset:]
+	if uint64(len(lis)) > search.Count {
+		lis = lis[:search.Count]
+	}
+
+	return lis
+}
@@ -0,0 +1,124 @@
+package mercury_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+)
+
+// pager pages its spaces in byte order like the sources the cursor expects.
+type pager mercury.Config
+
+func (p pager) GetIndex(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	var lis mercury.Config
+	for _, s := range p {
+		if search.NamespaceSearch.Match(s.Space) {
+			lis = append(lis, s)
+		}
+	}
+	sort.Sort(lis)
+	if search.Offset >= uint64(len(lis)) {
+		return nil, nil
+	}
+	lis = lis[search.Offset:]
+	if search.Count > 0 && uint64(len(lis)) > search.Count {
+		lis = lis[:search.Count]
+	}
+	return lis, nil
+}
+
+func (pager) Paged() bool { return true }
+
+// unpaged returns every match and leaves paging to the registry.
+type unpaged mercury.Config
+
+func (p unpaged) GetIndex(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	var lis mercury.Config
+	for _, s := range p {
+		if search.NamespaceSearch.Match(s.Space) {
+			lis = append(lis, s)
+		}
+	}
+	return lis, nil
+}
+
+func TestCursor(t *testing.T) {
+	is := is.New(t)
+
+	sources := map[string]pager{
+		"a": {mercury.NewSpace("test.1"), mercury.NewSpace("test.3"), mercury.NewSpace("test.5")},
+		"b": {mercury.NewSpace("test.2"), mercury.NewSpace("test.4")},
+	}
+	mercury.Registry.Register("test-pager", func(s *mercury.Space) any {
+		return sources[s.FirstValue("source").First()]
+	})
+
+	cfg := make(mercury.SpaceMap)
+	for name, priority := range map[string]string{"a": "1", "b": "2"} {
+		s := mercury.NewSpace("mercury.source.test-pager." + name)
+		s.AddKeys(
+			mercury.NewValue("match").SetValues(priority+" test.*"),
+			mercury.NewValue("source").SetValues(name),
+		)
+		cfg[s.Space] = s
+	}
+	is.NoErr(mercury.Registry.Configure(cfg))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	var got []string
+	search := mercury.ParseSearch("test.* count 2")
+	for i := 0; i < 5; i++ {
+		lis, next, err := mercury.Registry.GetIndexPage(context.Background(), search)
+		is.NoErr(err)
+		for _, s := range lis {
+			got = append(got, s.Space)
+		}
+		if next == "" {
+			break
+		}
+		search.Cursor = next
+	}
+
+	is.Equal(got, []string{"test.1", "test.2", "test.3", "test.4", "test.5"})
+}
+
+func TestCursorUnpaged(t *testing.T) {
+	is := is.New(t)
+
+	mercury.Registry.Register("test-pager", func(s *mercury.Space) any {
+		return pager{mercury.NewSpace("test.a"), mercury.NewSpace("test.c"), mercury.NewSpace("test.e")}
+	})
+	mercury.Registry.Register("test-unpaged", func(s *mercury.Space) any {
+		return unpaged{mercury.NewSpace("test.d"), mercury.NewSpace("test.b")}
+	})
+
+	cfg := make(mercury.SpaceMap)
+	for handler, priority := range map[string]string{"test-pager": "1", "test-unpaged": "2"} {
+		s := mercury.NewSpace("mercury.source." + handler + ".default")
+		s.AddKeys(mercury.NewValue("match").SetValues(priority + " test.*"))
+		cfg[s.Space] = s
+	}
+	is.NoErr(mercury.Registry.Configure(cfg))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	// a source that does not page has fewer matches than the count, so the
+	// registry must skip the ones already returned.
+	var got []string
+	search := mercury.ParseSearch("test.* count 2")
+	for i := 0; i < 5; i++ {
+		lis, next, err := mercury.Registry.GetIndexPage(context.Background(), search)
+		is.NoErr(err)
+		for _, s := range lis {
+			got = append(got, s.Space)
+		}
+		if next == "" {
+			break
+		}
+		search.Cursor = next
+	}
+
+	is.Equal(got, []string{"test.a", "test.b", "test.c", "test.d", "test.e"})
+}
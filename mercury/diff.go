@@ -0,0 +1,124 @@
+package mercury
+
+import (
+	"sort"
+	"strings"
+)
+
+// Diff returns a unified, value-level diff between two versions of a config.
+// Lines are rendered in the same `@space` / `name :value` layout as String
+// and prefixed with '-' or '+'. Unchanged values are omitted, the space
+// header is kept as context for each space that changed.
+func Diff(from, to Config) string {
+	fm, tm := from.ToSpaceMap(), to.ToSpaceMap()
+
+	var names []string
+	for name := range fm {
+		names = append(names, name)
+	}
+	for name := range tm {
+		if _, ok := fm[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, name := range names {
+		a, b := diffLines(fm[name]), diffLines(tm[name])
+		hunk := diffHunk(a, b)
+		if len(hunk) == 0 {
+			continue
+		}
+
+		if len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+			buf.WriteString(" ")
+			buf.WriteString(a[0])
+			buf.WriteRune('\n')
+		}
+		for _, line := range hunk {
+			buf.WriteString(line)
+			buf.WriteRune('\n')
+		}
+	}
+
+	return buf.String()
+}
+
+// diffLines renders a space as one line per value.
+func diffLines(s *Space) []string {
+	if s == nil {
+		return nil
+	}
+
+	var lis []string
+
+	head := "@" + s.Space
+	if len(s.Tags) > 0 {
+		head += " " + strings.Join(s.Tags, " ")
+	}
+	lis = append(lis, head)
+	for _, note := range s.Notes {
+		lis = append(lis, "# "+note)
+	}
+
+	for _, v := range s.List {
+		for _, note := range v.Notes {
+			lis = append(lis, "# "+note)
+		}
+		name := v.Name
+		if len(v.Tags) > 0 {
+			name += " " + strings.Join(v.Tags, " ")
+		}
+		if len(v.Values) == 0 {
+			lis = append(lis, name+" :")
+		}
+		for _, value := range v.Values {
+			lis = append(lis, name+" :"+value)
+		}
+	}
+
+	lis = append(lis, s.Trailer...)
+
+	return lis
+}
+
+// diffHunk returns the changed lines between a and b using the longest common subsequence.
+func diffHunk(a, b []string) (out []string) {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+
+	return out
+}
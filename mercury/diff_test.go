@@ -0,0 +1,45 @@
+package mercury_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+)
+
+func TestDiff(t *testing.T) {
+	is := is.New(t)
+
+	parse := func(text string) mercury.Config {
+		m, err := mercury.ParseText(strings.NewReader(text))
+		is.NoErr(err)
+		return m.ToArray()
+	}
+
+	from := parse(`
+@test.a
+host :db.local
+port :5432
+@test.b
+key  :value
+`)
+	to := parse(`
+@test.a
+host :db.prod
+port :5432
+@test.c
+key  :value
+`)
+
+	is.Equal(mercury.Diff(from, to), ` @test.a
+-host :db.local
++host :db.prod
+-@test.b
+-key :value
++@test.c
++key :value
+`)
+
+	is.Equal(mercury.Diff(from, from), "")
+}
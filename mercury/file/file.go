@@ -0,0 +1,316 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/mercury"
+)
+
+// Ext is the file extension read from the directory.
+const Ext = ".mercury"
+
+// DefaultInterval is how often the directory is checked for changes.
+var DefaultInterval = 5 * time.Second
+
+type fileHandler struct {
+	name     string
+	dir      string
+	readonly bool
+
+	mu     sync.RWMutex
+	spaces mercury.SpaceMap
+	origin map[string]string    // space name to file it was read from
+	stat   map[string]time.Time // file name to last modified
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	_ mercury.GetIndex      = (*fileHandler)(nil)
+	_ mercury.GetConfig     = (*fileHandler)(nil)
+	_ mercury.WriteConfig   = (*fileHandler)(nil)
+	_ mercury.WriteConfigIf = (*fileHandler)(nil)
+	_ mercury.Paged         = (*fileHandler)(nil)
+	_ io.Closer             = (*fileHandler)(nil)
+)
+
+// Register adds the file handler to the registry. It is configured with:
+//
+//	@mercury.source.file.<name> [readonly]
+//	match    :<priority> <search>
+//	path     :<directory of .mercury files>
+//	interval :<duration between checks for changes>
+func Register() {
+	mercury.Registry.Register("file", func(s *mercury.Space) any {
+		dir := s.FirstValue("path").First()
+		if dir == "" {
+			return fmt.Errorf("file: missing path")
+		}
+
+		interval := DefaultInterval
+		if v := s.FirstValue("interval").First(); v != "" {
+			var err error
+			if interval, err = time.ParseDuration(v); err != nil {
+				return err
+			}
+		}
+
+		h := &fileHandler{
+			name:     s.Space,
+			dir:      dir,
+			readonly: s.HasTag("readonly"),
+			stop:     make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		if err := h.load(); err != nil {
+			return err
+		}
+		go h.watch(interval)
+
+		return h
+	})
+}
+
+// load reads all the files in the directory.
+func (h *fileHandler) load() error {
+	files, err := filepath.Glob(filepath.Join(h.dir, "*"+Ext))
+	if err != nil {
+		return err
+	}
+
+	spaces := make(mercury.SpaceMap)
+	origin := make(map[string]string)
+	stat := make(map[string]time.Time, len(files))
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		stat[name] = fi.ModTime()
+
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		m, err := mercury.ParseText(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("file: %s: %w", name, err)
+		}
+
+		for space, s := range m {
+			if _, ok := spaces[space]; ok {
+				log.Println("file: duplicate space", space, "in", name)
+				continue
+			}
+			spaces[space] = s
+			origin[space] = name
+		}
+	}
+
+	h.mu.Lock()
+	h.spaces, h.origin, h.stat = spaces, origin, stat
+	h.mu.Unlock()
+
+	return nil
+}
+
+// changed reports if any files were added, removed or modified since the last load.
+func (h *fileHandler) changed() bool {
+	files, err := filepath.Glob(filepath.Join(h.dir, "*"+Ext))
+	if err != nil {
+		return false
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(files) != len(h.stat) {
+		return true
+	}
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return true
+		}
+		if mod, ok := h.stat[name]; !ok || !mod.Equal(fi.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads the directory when files change until closed.
+func (h *fileHandler) watch(interval time.Duration) {
+	defer close(h.done)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-tick.C:
+			if !h.changed() {
+				continue
+			}
+			if err := h.load(); err != nil {
+				log.Println("file: reload:", err)
+			}
+		}
+	}
+}
+
+// Close stops watching the directory.
+func (h *fileHandler) Close() error {
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+	<-h.done
+	return nil
+}
+
+// Paged reports that Count and Offset are applied by search.Filter.
+func (h *fileHandler) Paged() bool { return true }
+
+func (h *fileHandler) GetIndex(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	lis := search.Filter(h.spaces.ToArray())
+	for i, s := range lis {
+		lis[i] = &mercury.Space{Space: s.Space, Tags: s.Tags, Notes: s.Notes}
+	}
+
+	return lis, nil
+}
+
+func (h *fileHandler) GetConfig(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return search.Filter(h.spaces.ToArray()), nil
+}
+
+// WriteConfig writes spaces to the files they were read from.
+// New spaces are written to a file named for the space.
+func (h *fileHandler) WriteConfig(ctx context.Context, config mercury.Config) error {
+	return h.WriteConfigIf(ctx, config, nil)
+}
+
+// WriteConfigIf writes spaces if check passes for the current version of the spaces.
+func (h *fileHandler) WriteConfigIf(ctx context.Context, config mercury.Config, check func(current mercury.Config) error) error {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	if h.readonly {
+		return fmt.Errorf("readonly directory")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if check != nil {
+		var current mercury.Config
+		for _, s := range config {
+			if c, ok := h.spaces[s.Space]; ok {
+				current = append(current, c)
+			}
+		}
+		if err := check(current); err != nil {
+			return err
+		}
+	}
+
+	files := make(map[string]struct{})
+	for _, s := range config {
+		name, ok := h.origin[s.Space]
+		if !ok {
+			name = filepath.Join(h.dir, s.Space+Ext)
+			h.origin[s.Space] = name
+		}
+		files[name] = struct{}{}
+
+		if len(s.Tags) == 0 && len(s.Notes) == 0 && len(s.List) == 0 {
+			delete(h.spaces, s.Space)
+			delete(h.origin, s.Space)
+			continue
+		}
+		h.spaces[s.Space] = s
+	}
+
+	var errs error
+	for name := range files {
+		var lis mercury.Config
+		for space, origin := range h.origin {
+			if origin == name {
+				lis = append(lis, h.spaces[space])
+			}
+		}
+		sort.Sort(lis)
+
+		errs = errors.Join(errs, h.writeFile(name, lis))
+	}
+
+	return errs
+}
+
+// writeFile replaces the file with the spaces using an atomic rename.
+// The file is removed if there are no spaces left.
+func (h *fileHandler) writeFile(name string, lis mercury.Config) error {
+	if len(lis) == 0 {
+		delete(h.stat, name)
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(h.dir, "."+strings.TrimSuffix(filepath.Base(name), Ext)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.WriteString(tmp, lis.String())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(name); err == nil {
+		h.stat[name] = fi.ModTime()
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package file_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/file"
+)
+
+func TestFileHandler(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "app.mercury"), []byte(`
+@app.one
+key :one
+
+@app.two
+key :two
+`), 0o644)
+	is.NoErr(err)
+
+	file.DefaultInterval = 10 * time.Millisecond
+	file.Register()
+
+	src := mercury.NewSpace("mercury.source.file.test")
+	src.AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("path").SetValues(dir),
+	)
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	lis, err := mercury.Registry.GetIndex(ctx, mercury.ParseSearch("app.*"))
+	is.NoErr(err)
+	is.Equal(lis.StringList(), "@app.one\n@app.two\n")
+
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.two"))
+	is.NoErr(err)
+	is.Equal(len(lis), 1)
+	is.Equal(lis[0].FirstValue("key").First(), "two")
+
+	// updates are written back to the file the space was read from.
+	s := mercury.NewSpace("app.two")
+	s.AddKeys(mercury.NewValue("key").SetValues("2"))
+	is.NoErr(mercury.Registry.WriteConfig(ctx, mercury.Config{s}))
+
+	b, err := os.ReadFile(filepath.Join(dir, "app.mercury"))
+	is.NoErr(err)
+	is.True(strings.Contains(string(b), "@app.one"))
+	is.True(strings.Contains(string(b), ":2\n"))
+
+	// new spaces get their own file and empty spaces are removed.
+	is.NoErr(mercury.Registry.WriteConfig(ctx, mercury.Config{
+		mercury.NewSpace("app.one"),
+		mercury.NewSpace("app.two"),
+		mercury.NewSpace("app.three").AddKeys(mercury.NewValue("key").SetValues("three")),
+	}))
+	_, err = os.Stat(filepath.Join(dir, "app.mercury"))
+	is.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "app.three.mercury"))
+	is.NoErr(err)
+
+	// edits made outside the handler are picked up.
+	err = os.WriteFile(filepath.Join(dir, "other.mercury"), []byte("@app.four\nkey :four\n"), 0o644)
+	is.NoErr(err)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lis, err = mercury.Registry.GetIndex(ctx, mercury.ParseSearch("app.*"))
+		is.NoErr(err)
+		if lis.StringList() == "@app.four\n@app.three\n" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	is.Equal(lis.StringList(), "@app.four\n@app.three\n")
+}
+
+func TestFileReadonly(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	file.Register()
+
+	src := mercury.NewSpace("mercury.source.file.readonly").SetTags("readonly")
+	src.AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("path").SetValues(dir),
+	)
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	s := mercury.NewSpace("app.one").AddKeys(mercury.NewValue("key").SetValues("one"))
+	is.NoErr(mercury.Registry.WriteConfig(context.Background(), mercury.Config{s}))
+
+	// readonly sources are not matched for writes.
+	files, err := os.ReadDir(dir)
+	is.NoErr(err)
+	is.Equal(len(files), 0)
+}
@@ -0,0 +1,202 @@
+package mercury
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter applies the search to spaces held in memory. It is the reference for
+// how backends evaluate a search: spaces must match the namespace and every
+// find op, are sorted by name, paged by Offset and Count, and only the named
+// Fields are kept. Spaces are copied when fields are dropped.
+func (search Search) Filter(lis Config) Config {
+	var out Config
+	for _, s := range lis {
+		if search.MatchSpace(s) {
+			out = append(out, s)
+		}
+	}
+	sort.Sort(out)
+
+	if search.Offset > 0 {
+		if search.Offset >= uint64(len(out)) {
+			return Config{}
+		}
+		out = out[search.Offset:]
+	}
+	if search.Count > 0 && uint64(len(out)) > search.Count {
+		out = out[:search.Count]
+	}
+
+	if len(search.Fields) > 0 {
+		for i, s := range out {
+			out[i] = s.Project(search.Fields...)
+		}
+	}
+
+	return out
+}
+
+// MatchSpace returns true if the space matches the namespace and every find op.
+func (search Search) MatchSpace(s *Space) bool {
+	if !search.NamespaceSearch.Match(s.Space) {
+		return false
+	}
+	for _, o := range search.Find {
+		if !o.Match(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Project returns a copy of the space with only the named values.
+func (s *Space) Project(fields ...string) *Space {
+	out := &Space{Space: s.Space, Tags: s.Tags, Notes: s.Notes, Trailer: s.Trailer}
+	for _, v := range s.List {
+		if slices.Contains(fields, v.Name) {
+			out.List = append(out.List, v)
+		}
+	}
+	return out
+}
+
+// Match returns true if the space satisfies the op.
+//
+//	key  => has a value named Left
+//	nkey => does not have a value named Left
+//	eq   => Left has a value equal to Right
+//	neq  => Left is set and has no value equal to Right
+//	gt, lt, ge, le => Left has a value that compares to Right.
+//	        numbers are compared as numbers, otherwise as strings.
+//	like => Left has a value matching the SQL pattern Right (% and _)
+//	in   => Left has a value in Values
+//	and, or, not => combine the ops in Terms
+func (o FindOp) Match(s *Space) bool {
+	switch o.Op {
+	case "and":
+		for _, t := range o.Terms {
+			if !t.Match(s) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, t := range o.Terms {
+			if t.Match(s) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		for _, t := range o.Terms {
+			if t.Match(s) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var values []string
+	var found bool
+	for _, v := range s.List {
+		if v.Name == o.Left {
+			found = true
+			values = append(values, v.Values...)
+		}
+	}
+
+	switch o.Op {
+	case "key":
+		return found
+	case "nkey":
+		return !found
+	case "neq":
+		return found && !slices.Contains(values, o.Right)
+	}
+
+	for _, v := range values {
+		switch o.Op {
+		case "eq":
+			if v == o.Right {
+				return true
+			}
+		case "gt":
+			if c, ok := compare(v, o.Right); ok && c > 0 {
+				return true
+			}
+		case "lt":
+			if c, ok := compare(v, o.Right); ok && c < 0 {
+				return true
+			}
+		case "ge":
+			if c, ok := compare(v, o.Right); ok && c >= 0 {
+				return true
+			}
+		case "le":
+			if c, ok := compare(v, o.Right); ok && c <= 0 {
+				return true
+			}
+		case "like":
+			if like(o.Right, v) {
+				return true
+			}
+		case "in":
+			if slices.Contains(o.Values, v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// compare orders a value against a number if b is one, otherwise as strings.
+// A value that is not a number does not compare to one, as in the sql sources.
+func compare(a, b string) (int, bool) {
+	y, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return strings.Compare(a, b), true
+	}
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// like matches s against a SQL LIKE pattern.
+func like(pattern, s string) bool {
+	p, r := []rune(pattern), []rune(s)
+
+	// star and mark record the last % seen to backtrack to.
+	var i, j int
+	star, mark := -1, 0
+	for j < len(r) {
+		switch {
+		case i < len(p) && p[i] == '%':
+			star, mark = i, j
+			i++
+		case i < len(p) && (p[i] == '_' || p[i] == r[j]):
+			i++
+			j++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '%' {
+		i++
+	}
+	return i == len(p)
+}
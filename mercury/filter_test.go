@@ -0,0 +1,87 @@
+package mercury_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+)
+
+func TestSearchFilter(t *testing.T) {
+	m, err := mercury.ParseText(strings.NewReader(`
+@app.one
+host :db.prod
+port :5432
+
+@app.two
+host :db.local
+port :80
+path :/var/lib/app
+version :10
+
+@app.three
+port :8080
+version :beta
+
+@other
+host :db.prod
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := m.ToArray()
+
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"app.*", "@app.one\n@app.three\n@app.two\n"},
+		{"app.*|other", "@app.one\n@app.three\n@app.two\n@other\n"},
+		{"app.* find host=key=", "@app.one\n@app.two\n"},
+		{"app.* find host=nkey=", "@app.three\n"},
+		{"* find host=eq=db.prod", "@app.one\n@other\n"},
+		{"app.* find host=neq=db.prod", "@app.two\n"},
+		{"app.* find port=gt=1000", "@app.one\n@app.three\n"},
+		{"app.* find port=le=5432", "@app.one\n@app.two\n"},
+		{"app.* find host=like=db.%", "@app.one\n@app.two\n"},
+		{"app.* find path=like=%/lib/%", "@app.two\n"},
+		{"app.* find host=like=db._rod", "@app.one\n"},
+		{"app.* find host=key=,port=lt=100", "@app.two\n"},
+		{"app.* find version=gt=5", "@app.two\n"},
+		{"app.* find version=gt=alpha", "@app.three\n"},
+		{"app.* find host=eq=db.prod,port=eq=80", ""},
+		{"app.* find host==db.prod,host==db.local", "@app.one\n@app.two\n"},
+		{"app.* find (host==db.prod,host==db.local)", "@app.one\n@app.two\n"},
+		{"app.* find (host==db.prod,host==db.local);port<1000", "@app.two\n"},
+		{"app.* find !(host=like=db.*)", "@app.three\n"},
+		{"app.* find !host", "@app.three\n"},
+		{"app.* find port=in=(80,8080)", "@app.three\n@app.two\n"},
+		{"app.* find port=out=[80,8080]", "@app.one\n"},
+		{"app.* find path!~*/lib/*;host", "@app.one\n"},
+		{"app.* count 2", "@app.one\n@app.three\n"},
+		{"app.* count 2 offset 2", "@app.two\n"},
+		{"app.* offset 5", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(mercury.ParseSearch(tt.search).Filter(lis).StringList(), tt.want)
+		})
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	is := is.New(t)
+
+	m, err := mercury.ParseText(strings.NewReader("@app\nhost :db\nport :5432\nuser :app\n"))
+	is.NoErr(err)
+
+	lis := mercury.ParseSearch("app fields host,user").Filter(m.ToArray())
+	is.Equal(lis.EnvString(), "app:host=db\napp:user=app\n")
+
+	// the stored space is not changed.
+	s, _ := m.Space("app")
+	is.Equal(len(s.List), 3)
+}
@@ -3,61 +3,173 @@ package http
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"text/template"
 
+	"go.sour.is/pkg/authreq"
+	"go.sour.is/pkg/env"
 	"go.sour.is/pkg/lg"
 	"go.sour.is/pkg/mercury"
 )
 
-type httpNotify struct{}
+type httpNotify struct {
+	client *http.Client
+	key    ed25519.PrivateKey
+}
+
+var _ mercury.SendNotify = (*httpNotify)(nil)
 
-func (httpNotify) SendNotify(ctx context.Context, n mercury.Notify) error {
+// funcs are available to notify body templates.
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (h *httpNotify) SendNotify(ctx context.Context, n mercury.Notify) error {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
-	cl := &http.Client{}
-	caCertPool, err := x509.SystemCertPool()
+	body, err := notifyBody(n)
 	if err != nil {
-		caCertPool = x509.NewCertPool()
-	}
-
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		RootCAs: caCertPool,
+		span.RecordError(err)
+		return err
 	}
 
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-
-	cl.Transport = transport
-
 	var req *http.Request
-	req, err = http.NewRequestWithContext(ctx, n.Method, n.URL, bytes.NewBufferString(""))
+	req, err = http.NewRequestWithContext(ctx, n.Method, n.URL, bytes.NewReader(body))
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
-	req.Header.Set("content-type", "application/json")
+	if n.Template == "" {
+		req.Header.Set("content-type", "application/json")
+	}
+	for _, line := range strings.Split(n.Header, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if h.key != nil {
+		req, err = authreq.Sign(req, h.key)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
 
 	span.AddEvent(fmt.Sprint("URL: ", n.URL))
-	res, err := cl.Do(req)
+	res, err := h.client.Do(req)
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
 	res.Body.Close()
 	span.AddEvent(fmt.Sprint(res.Status))
-	if res.StatusCode != 200 {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = fmt.Errorf("http-notify: %s %s: %s", n.Method, n.URL, res.Status)
 		span.RecordError(err)
-		err = fmt.Errorf("unable to read config")
 		return err
 	}
 
 	return nil
 }
 
+// notifyBody formats the payload of the notify as JSON or with its template.
+// A template body is sent without a content type unless a rule header sets one.
+func notifyBody(n mercury.Notify) ([]byte, error) {
+	payload := n.Payload
+	if payload == nil {
+		payload = &mercury.NotifyPayload{Event: n.Event, Name: n.Name}
+	}
+
+	if n.Template == "" {
+		return json.Marshal(payload)
+	}
+
+	tmpl, err := template.New(n.Name).Funcs(funcs).Parse(n.Template)
+	if err != nil {
+		return nil, fmt.Errorf("http-notify: %s: %w", n.Name, err)
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("http-notify: %s: %w", n.Name, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseKey reads an ed25519 key as a base64 url encoded seed or private key.
+func parseKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("http-notify: key: %w", err)
+	}
+
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, fmt.Errorf("http-notify: key: expected %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(b))
+	}
+}
+
+// Register adds the http notify handler to the registry. It is configured with:
+//
+//	@mercury.output.http-notify.<name>
+//	match :<priority> http|https
+//	key   :<base64 url ed25519 seed>
+//
+// Notifies are routed to the handler when the match fits their method or URL
+// scheme.
+// Requests are signed with authreq.Sign using the key, or MERCURY_NOTIFY_KEY
+// if it is not set. Without a key requests are sent unsigned. The signature
+// covers the method, full URL and body, and the issuer of the token is the
+// public key receivers should trust.
 func Register() {
-	mercury.Registry.Register("http-notify", func(s *mercury.Space) any { return httpNotify{} })
+	mercury.Registry.Register("http-notify", func(s *mercury.Space) any {
+		h := &httpNotify{}
+
+		key := s.FirstValue("key").First()
+		if key == "" {
+			key = env.Secret("MERCURY_NOTIFY_KEY", "").Secret()
+		}
+		if key != "" {
+			var err error
+			if h.key, err = parseKey(key); err != nil {
+				return err
+			}
+		} else {
+			log.Println("http-notify: no key set, sending", s.Space, "unsigned")
+		}
+
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil {
+			caCertPool = x509.NewCertPool()
+		}
+
+		// Setup HTTPS client
+		tlsConfig := &tls.Config{
+			RootCAs: caCertPool,
+		}
+
+		h.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		return h
+	})
 }
@@ -0,0 +1,117 @@
+package http_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/authreq"
+	"go.sour.is/pkg/mercury"
+	mercuryhttp "go.sour.is/pkg/mercury/http"
+)
+
+func TestSendNotify(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	is.NoErr(err)
+
+	type request struct {
+		issuer string
+		header http.Header
+		body   string
+	}
+	reqs := make(chan request, 1)
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	verify := authreq.Authorization(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.WriteHeader(int(status.Load()))
+		reqs <- request{authreq.FromContext(r.Context()).Issuer, r.Header, string(b)}
+	}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the signature covers the URL as it was sent.
+		r.URL.Scheme, r.URL.Host = "http", r.Host
+		verify.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	mercuryhttp.Register()
+	out := mercury.NewSpace("mercury.output.http-notify.test")
+	out.AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("key").SetValues(base64.RawURLEncoding.EncodeToString(priv.Seed())),
+	)
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{out.Space: out}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	notify := mercury.ParseNotify(mercury.NewSpace("mercury.notify").AddKeys(
+		mercury.NewValue("json").SetValues("app.* updated POST "+srv.URL+"/hook"),
+		mercury.NewValue("text").SetValues(
+			"app.* updated PUT "+srv.URL+"/text",
+			"header Content-Type: text/plain",
+			"header X-Token: abc",
+			`template {{.Identity}} updated {{join .Spaces ", "}}`,
+		),
+		mercury.NewValue("form").SetValues(
+			"app.* updated POST "+srv.URL+"/form",
+			"template spaces={{join .Spaces \",\"}}",
+		),
+	), "updated")
+	is.Equal(len(notify), 3)
+
+	payload := &mercury.NotifyPayload{
+		Event:    "updated",
+		Spaces:   []string{"app.one", "app.two"},
+		Identity: "user",
+		Hash:     "abc123",
+	}
+
+	// the default body is the payload as JSON and any 2xx is a success.
+	n := notify[0]
+	n.Payload = payload
+	is.NoErr(mercury.Registry.SendNotify(ctx, n))
+	req := <-reqs
+	is.Equal(req.issuer, base64.RawURLEncoding.EncodeToString(pub))
+	is.Equal(req.header.Get("Content-Type"), "application/json")
+
+	var got mercury.NotifyPayload
+	is.NoErr(json.Unmarshal([]byte(req.body), &got))
+	is.Equal(got.Spaces, payload.Spaces)
+	is.Equal(got.Identity, "user")
+	is.Equal(got.Hash, "abc123")
+
+	// headers and the body template come from the rule.
+	status.Store(http.StatusAccepted)
+	n = notify[1]
+	n.Payload = payload
+	is.NoErr(mercury.Registry.SendNotify(ctx, n))
+	req = <-reqs
+	is.Equal(req.header.Get("Content-Type"), "text/plain")
+	is.Equal(req.header.Get("X-Token"), "abc")
+	is.Equal(req.body, "user updated app.one, app.two")
+
+	// a template without a content type header is sent without one.
+	n = notify[2]
+	n.Payload = payload
+	is.NoErr(mercury.Registry.SendNotify(ctx, n))
+	req = <-reqs
+	is.Equal(req.header.Get("Content-Type"), "")
+	is.Equal(req.body, "spaces=app.one,app.two")
+
+	n = notify[1]
+	status.Store(http.StatusInternalServerError)
+	err = mercury.Registry.SendNotify(ctx, n)
+	<-reqs
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "500"))
+}
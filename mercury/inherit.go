@@ -0,0 +1,108 @@
+package mercury
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.sour.is/pkg/ident"
+)
+
+// inheritor layers the keys of parent spaces named by extends/<space> tags
+// under the keys of a space. Keys of the space override the parent by name
+// and the first parent listed takes priority. Parents are read through the
+// registry and must be readable by the user.
+//
+//	@app.base
+//	host :db
+//	port :5432
+//
+//	@app.prod extends/app.base
+//	host :db.prod
+type inheritor struct {
+	ctx    context.Context
+	r      *registry
+	rules  Rules
+	spaces SpaceMap
+}
+
+func (r *registry) inherit(ctx context.Context, lis Config) (Config, error) {
+	rules, err := r.GetRules(ctx, ident.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	in := &inheritor{ctx: ctx, r: r, rules: rules, spaces: lis.ToSpaceMap()}
+
+	out := make(Config, len(lis))
+	for i, s := range lis {
+		out[i] = s
+		if !s.HasTag("extends/") {
+			continue
+		}
+		if out[i], err = in.flatten(s, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+func (in *inheritor) flatten(s *Space, stack []string) (*Space, error) {
+	if slices.Contains(stack, s.Space) {
+		return nil, fmt.Errorf("%w cycle: %s -> %s", ErrReference, strings.Join(stack, " -> "), s.Space)
+	}
+	stack = append(stack, s.Space)
+
+	c := s.Clone()
+	for i := 0; ; i++ {
+		name := s.GetTagMeta("extends", i)
+		if name == "" {
+			break
+		}
+
+		parent, err := in.space(name)
+		if err != nil {
+			return nil, err
+		}
+		if parent, err = in.flatten(parent, stack); err != nil {
+			return nil, err
+		}
+		reveal := in.rules.GetRoles("NS", name).HasRole("reveal")
+
+		var list []Value
+		for _, v := range parent.List {
+			if c.indexOf(v.Name) >= 0 {
+				continue
+			}
+			if v.HasTag(SecretTag) && !reveal {
+				v.Values = slices.Repeat([]string{Redacted}, len(v.Values))
+			}
+			list = append(list, v)
+		}
+		c.List = append(list, c.List...)
+	}
+
+	return c, nil
+}
+
+func (in *inheritor) space(name string) (*Space, error) {
+	if !canRead(in.rules, name) {
+		return nil, fmt.Errorf("%w: extends/%s: access denied", ErrReference, name)
+	}
+	if s, ok := in.spaces[name]; ok {
+		return s, nil
+	}
+
+	lis, err := in.r.GetConfig(in.ctx, ParseSearch(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(lis) == 0 {
+		return nil, fmt.Errorf("%w: extends/%s: no such space", ErrReference, name)
+	}
+	in.spaces[name] = lis[0]
+
+	return lis[0], nil
+}
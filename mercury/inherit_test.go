@@ -0,0 +1,86 @@
+package mercury_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+)
+
+func TestInherit(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	seed, err := mercury.ParseText(strings.NewReader(`
+@mercury.groups
+readers :anon
+
+@mercury.policy
+readers :read NS app.*
+
+@app.base
+host            :db
+port            :5432
+password secret :hunter2
+
+@app.prod extends/app.base
+host :db.prod
+
+@app.canary extends/app.prod
+port :6543
+
+@app.loop extends/app.loop2
+a :1
+
+@app.loop2 extends/app.loop
+b :2
+
+@app.private extends/private
+a :1
+
+@private
+b :2
+`))
+	is.NoErr(err)
+
+	mercury.Registry.Register("test-inherit", func(s *mercury.Space) any { return mem.New(seed) })
+	src := mercury.NewSpace("mercury.source.test-inherit.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	// the raw child is returned without inherit.
+	lis, err := mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.canary"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), "app.canary extends/app.prod:port=6543\n")
+
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.canary inherit"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), strings.Join([]string{
+		"app.canary extends/app.prod:password secret=***",
+		"app.canary extends/app.prod:host=db.prod",
+		"app.canary extends/app.prod:port=6543",
+		"",
+	}, "\n"))
+
+	// fields are projected after the parents are layered in.
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.prod inherit fields host,port"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), "app.prod extends/app.base:port=5432\napp.prod extends/app.base:host=db.prod\n")
+
+	for _, tt := range []struct {
+		space string
+		want  string
+	}{
+		{"app.loop", "reference cycle: app.loop -> app.loop2 -> app.loop"},
+		{"app.private", "reference: extends/private: access denied"},
+	} {
+		_, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch(tt.space+" inherit"))
+		is.True(errors.Is(err, mercury.ErrReference))
+		is.Equal(err.Error(), tt.want)
+	}
+}
@@ -0,0 +1,409 @@
+package mercury
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldTag is a parsed `mercury` struct tag.
+type fieldTag struct {
+	name      string
+	meta      string
+	notes     bool
+	tags      bool
+	space     bool
+	omitempty bool
+}
+
+func parseFieldTag(f reflect.StructField) (fieldTag, bool) {
+	tag, ok := f.Tag.Lookup("mercury")
+	if tag == "-" {
+		return fieldTag{}, false
+	}
+
+	var ft fieldTag
+	name, opts, _ := strings.Cut(tag, ",")
+	ft.name = name
+	if !ok {
+		ft.name = strings.ToLower(f.Name[:1]) + f.Name[1:]
+	}
+
+	for _, opt := range strings.Split(opts, ",") {
+		switch {
+		case opt == "notes":
+			ft.notes = true
+		case opt == "tags":
+			ft.tags = true
+		case opt == "space":
+			ft.space = true
+		case opt == "omitempty":
+			ft.omitempty = true
+		case strings.HasPrefix(opt, "tag="):
+			ft.meta = strings.TrimPrefix(opt, "tag=")
+		}
+	}
+
+	return ft, true
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isNested returns true if the type is a struct that holds prefixed keys.
+func isNested(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || t == urlType {
+		return false
+	}
+	return !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// Unmarshal decodes the values of a space into the struct pointed to by v
+// using the `mercury` struct tags. Keys that are not in the space leave the
+// field unchanged. Values are parsed as the type of the field, slices take
+// every value of the key and nested structs read keys with a name. prefix.
+//
+//	Name    string        `mercury:"name"`            => value of key name
+//	Peers   []string      `mercury:"peers"`           => every value of key peers
+//	Region  string        `mercury:"host,tag=region"` => tag meta of key host, eg. host region/eu :...
+//	About   []string      `mercury:"host,notes"`      => notes of key host
+//	DB      DBConfig      `mercury:"db"`              => nested keys with prefix db. eg. db.host
+//	Space   string        `mercury:",space"`          => the space name
+//	Tags    []string      `mercury:",tags"`           => the space tags
+//	Notes   []string      `mercury:",notes"`          => the space notes
+//	Port    int           `mercury:"port,omitempty"`  => not marshaled when zero
+//	Ignored string        `mercury:"-"`
+//
+// Fields without a tag use the field name with the first letter lowered.
+func Unmarshal(space *Space, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("mercury: unmarshal needs a pointer to a struct, got %T", v)
+	}
+
+	return unmarshalStruct(space, "", rv.Elem())
+}
+
+func unmarshalStruct(s *Space, prefix string, rv reflect.Value) error {
+	var errs error
+
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		ft, ok := parseFieldTag(f)
+		if !ok {
+			continue
+		}
+		fv := rv.Field(i)
+
+		if ft.name == "" {
+			var err error
+			switch {
+			case ft.space:
+				err = setValues(fv, []string{s.Space})
+			case ft.tags:
+				err = setValues(fv, s.Tags)
+			case ft.notes:
+				err = setValues(fv, s.Notes)
+			}
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("mercury: %s: %w", f.Name, err))
+			}
+			continue
+		}
+
+		name := prefix + ft.name
+		if ft.meta == "" && !ft.notes && isNested(f.Type) {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					fv.Set(reflect.New(f.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			errs = errors.Join(errs, unmarshalStruct(s, name+".", fv))
+			continue
+		}
+
+		var values, tags, notes []string
+		var found bool
+		for _, v := range s.List {
+			if v.Name == name {
+				found = true
+				values = append(values, v.Values...)
+				tags = append(tags, v.Tags...)
+				notes = append(notes, v.Notes...)
+			}
+		}
+		if !found {
+			continue
+		}
+
+		var err error
+		switch {
+		case ft.meta != "":
+			err = setValues(fv, tagMeta(tags, ft.meta))
+		case ft.notes:
+			err = setValues(fv, notes)
+		default:
+			err = setValues(fv, values)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("mercury: %s: %w", name, err))
+		}
+	}
+
+	return errs
+}
+
+// tagMeta returns the meta value of each tag named needle.
+func tagMeta(tags []string, needle string) (lis []string) {
+	for _, t := range tags {
+		if meta, ok := strings.CutPrefix(t, needle+"/"); ok {
+			lis = append(lis, meta)
+		}
+	}
+	return
+}
+
+// setValues sets a field from a list of values. Slices take every value and
+// other types take the first.
+func setValues(fv reflect.Value, values []string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		lis := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := setValue(lis.Index(i), v); err != nil {
+				return err
+			}
+		}
+		fv.Set(lis)
+		return nil
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	return setValue(fv, values[0])
+}
+
+// setValue parses a single value as the type of the field.
+func setValue(fv reflect.Value, v string) error {
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setValue(fv.Elem(), v)
+	}
+
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(strings.TrimSpace(v)))
+	}
+
+	switch fv.Type() {
+	case durationType:
+		d, err := parseDuration(v)
+		if err == nil {
+			fv.SetInt(int64(d))
+		}
+		return err
+	case urlType:
+		u, err := parseURL(v)
+		if err == nil {
+			fv.Set(reflect.ValueOf(*u))
+		}
+		return err
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(v)
+	case reflect.Slice:
+		fv.SetBytes([]byte(v))
+	case reflect.Bool:
+		b, err := parseBool(v)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := parseInt(v)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, fv.Type())
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(strings.TrimSpace(v), 0, 64)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowUint(u) {
+			return fmt.Errorf("%d overflows %s", u, fv.Type())
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+
+	return nil
+}
+
+// Marshal encodes the struct v as a space using the `mercury` struct tags.
+// See Unmarshal for how fields are mapped to keys.
+func Marshal(v any) *Space {
+	s := &Space{}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return s
+	}
+	marshalStruct(s, "", rv)
+
+	for i := range s.List {
+		s.List[i].Space = s.Space
+		s.List[i].Seq = uint64(i + 1)
+	}
+
+	return s
+}
+
+func marshalStruct(s *Space, prefix string, rv reflect.Value) {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		ft, ok := parseFieldTag(f)
+		if !ok {
+			continue
+		}
+		fv := rv.Field(i)
+		if ft.omitempty && fv.IsZero() {
+			continue
+		}
+
+		if ft.name == "" {
+			switch {
+			case ft.space:
+				s.Space = first(formatValues(fv))
+			case ft.tags:
+				s.Tags = append(s.Tags, formatValues(fv)...)
+			case ft.notes:
+				s.Notes = append(s.Notes, formatValues(fv)...)
+			}
+			continue
+		}
+
+		name := prefix + ft.name
+		if ft.meta == "" && !ft.notes && isNested(f.Type) {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			marshalStruct(s, name+".", fv)
+			continue
+		}
+
+		idx := s.indexOf(name)
+		if idx < 0 {
+			s.List = append(s.List, Value{Name: name})
+			idx = len(s.List) - 1
+		}
+		v := &s.List[idx]
+
+		switch {
+		case ft.meta != "":
+			for _, meta := range formatValues(fv) {
+				v.Tags = append(v.Tags, ft.meta+"/"+meta)
+			}
+		case ft.notes:
+			v.Notes = append(v.Notes, formatValues(fv)...)
+		default:
+			v.Values = append(v.Values, formatValues(fv)...)
+		}
+	}
+}
+
+func first(lis []string) string {
+	if len(lis) == 0 {
+		return ""
+	}
+	return lis[0]
+}
+
+// formatValues formats a field as a list of values.
+func formatValues(fv reflect.Value) []string {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		lis := make([]string, fv.Len())
+		for i := range lis {
+			lis[i] = formatValue(fv.Index(i))
+		}
+		return lis
+	}
+	if fv.Kind() == reflect.Pointer && fv.IsNil() {
+		return nil
+	}
+	return []string{formatValue(fv)}
+}
+
+// formatValue formats a single value.
+func formatValue(fv reflect.Value) string {
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return ""
+		}
+		fv = fv.Elem()
+	}
+
+	if fv.Type().Implements(textMarshalerType) {
+		b, _ := fv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b)
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textMarshalerType) {
+		b, _ := fv.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b)
+	}
+
+	switch fv.Type() {
+	case durationType:
+		return time.Duration(fv.Int()).String()
+	case urlType:
+		u := fv.Interface().(url.URL)
+		return u.String()
+	}
+
+	switch fv.Kind() {
+	case reflect.Slice:
+		return string(fv.Bytes())
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits())
+	}
+
+	return fmt.Sprint(fv.Interface())
+}
@@ -0,0 +1,113 @@
+package mercury_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+)
+
+type testDB struct {
+	Host string `mercury:"host"`
+	Port int    `mercury:"port"`
+}
+
+type testApp struct {
+	Name    string        `mercury:",space"`
+	Tags    []string      `mercury:",tags"`
+	Notes   []string      `mercury:",notes"`
+	Host    string        `mercury:"host"`
+	Region  string        `mercury:"host,tag=region"`
+	About   []string      `mercury:"host,notes"`
+	Timeout time.Duration `mercury:"timeout"`
+	Debug   bool          `mercury:"debug"`
+	Peers   []string      `mercury:"peers"`
+	Ratio   float64       `mercury:"ratio,omitempty"`
+	DB      testDB        `mercury:"db"`
+	Cache   *testDB       `mercury:"cache"`
+	Secret  string        `mercury:"-"`
+	Started time.Time
+}
+
+func TestUnmarshal(t *testing.T) {
+	is := is.New(t)
+
+	m, err := mercury.ParseText(strings.NewReader(`
+# application settings
+@app.one readonly
+# the primary host
+host region/eu :app.example.com
+timeout        :5s
+debug          :yes
+peers          :a
+               :b
+peers          :c
+db.host        :db.example.com
+db.port        :5432
+cache.port     :6379
+started        :2024-01-02T03:04:05Z
+`))
+	is.NoErr(err)
+	s, _ := m.Space("app.one")
+
+	var app testApp
+	is.NoErr(mercury.Unmarshal(s, &app))
+
+	is.Equal(app.Name, "app.one")
+	is.Equal(app.Tags, []string{"readonly"})
+	is.Equal(app.Notes, []string{"application settings"})
+	is.Equal(app.Host, "app.example.com")
+	is.Equal(app.Region, "eu")
+	is.Equal(app.About, []string{"the primary host"})
+	is.Equal(app.Timeout, 5*time.Second)
+	is.True(app.Debug)
+	is.Equal(app.Peers, []string{"a", "b", "c"})
+	is.Equal(app.DB, testDB{"db.example.com", 5432})
+	is.Equal(*app.Cache, testDB{"", 6379})
+	is.Equal(app.Started, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	var bad struct {
+		Port int `mercury:"db.host"`
+	}
+	is.True(mercury.Unmarshal(s, &bad) != nil)
+	is.True(mercury.Unmarshal(s, bad) != nil)
+}
+
+func TestMarshal(t *testing.T) {
+	is := is.New(t)
+
+	app := testApp{
+		Name:    "app.one",
+		Tags:    []string{"readonly"},
+		Host:    "app.example.com",
+		Region:  "eu",
+		Timeout: 5 * time.Second,
+		Peers:   []string{"a", "b"},
+		DB:      testDB{"db.example.com", 5432},
+		Secret:  "hidden",
+		Started: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	s := mercury.Marshal(app)
+	is.Equal(s.Space, "app.one")
+	is.Equal(mercury.Config{s}.EnvString(), strings.Join([]string{
+		"app.one readonly:host region/eu=app.example.com",
+		"app.one readonly:timeout=5s",
+		"app.one readonly:debug=false",
+		"app.one readonly:peers+=a",
+		"app.one:peers+=b",
+		"app.one readonly:db.host=db.example.com",
+		"app.one readonly:db.port=5432",
+		"app.one readonly:started=2024-01-02T03:04:05Z",
+		"",
+	}, "\n"))
+
+	var out testApp
+	is.NoErr(mercury.Unmarshal(s, &out))
+	is.Equal(out.DB, app.DB)
+	is.Equal(out.Peers, app.Peers)
+	is.Equal(out.Region, app.Region)
+	is.Equal(out.Started, app.Started)
+}
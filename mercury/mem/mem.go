@@ -0,0 +1,368 @@
+package mem
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sour.is/pkg/ident"
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/mercury"
+)
+
+const (
+	mercuryGroups = "mercury.groups"
+	mercuryPolicy = "mercury.policy"
+)
+
+// Handler holds spaces in memory. It implements every registry interface but
+// GetNotify, as the registry reads notify rules from the spaces, and evaluates
+// searches with mercury.Search.Filter.
+type Handler struct {
+	mu      sync.RWMutex
+	spaces  mercury.SpaceMap
+	history []revision
+	sent    mercury.ListNotify
+	audit   []mercury.Audit
+	outbox  []mercury.OutboxItem
+	nextID  uint64
+}
+
+// revision is a version of a space. A nil space was deleted.
+type revision struct {
+	created time.Time
+	space   string
+	value   *mercury.Space
+}
+
+var (
+	_ mercury.GetIndex      = (*Handler)(nil)
+	_ mercury.GetConfig     = (*Handler)(nil)
+	_ mercury.WriteConfig   = (*Handler)(nil)
+	_ mercury.WriteConfigIf = (*Handler)(nil)
+	_ mercury.GetRules      = (*Handler)(nil)
+	_ mercury.SendNotify    = (*Handler)(nil)
+	_ mercury.WriteAudit    = (*Handler)(nil)
+	_ mercury.ReadAudit     = (*Handler)(nil)
+	_ mercury.Paged         = (*Handler)(nil)
+
+	_ mercury.Outbox            = (*Handler)(nil)
+	_ mercury.WriteConfigNotify = (*Handler)(nil)
+)
+
+// Register adds the mem handler to the registry. Each configured source
+// starts with its own copy of seed.
+//
+//	@mercury.source.mem.<name>
+//	match :<priority> <search>
+func Register(seed mercury.SpaceMap) {
+	mercury.Registry.Register("mem", func(s *mercury.Space) any { return New(seed) })
+}
+
+// New returns a handler holding a copy of seed.
+func New(seed mercury.SpaceMap) *Handler {
+	h := &Handler{spaces: make(mercury.SpaceMap, len(seed))}
+
+	// when the seed was written is not known, so reads at any time find it.
+	for _, s := range seed.ToArray() {
+		s = s.Clone()
+		h.spaces[s.Space] = s
+		h.history = append(h.history, revision{time.Time{}, s.Space, s})
+	}
+
+	return h
+}
+
+// Paged reports that Count and Offset are applied by search.Filter.
+func (h *Handler) Paged() bool { return true }
+
+// GetIndex returns the spaces matching search without their values.
+func (h *Handler) GetIndex(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	lis, err := h.GetConfig(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+
+	config := make(mercury.Config, len(lis))
+	for i, s := range lis {
+		config[i] = &mercury.Space{Space: s.Space, Tags: s.Tags, Notes: s.Notes}
+	}
+
+	return config, nil
+}
+
+// GetConfig returns copies of the spaces matching search.
+func (h *Handler) GetConfig(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	lis := h.spaces.ToArray()
+	if search.At != nil {
+		lis = h.at(*search.At)
+	}
+
+	return clone(search.Filter(lis)), nil
+}
+
+// clone copies the spaces so callers can not change those held.
+func clone(lis mercury.Config) mercury.Config {
+	out := make(mercury.Config, len(lis))
+	for i, s := range lis {
+		out[i] = s.Clone()
+	}
+	return out
+}
+
+// at returns the spaces as they were at the point in time. Revisions are
+// numbered from 1 in the order they were written.
+func (h *Handler) at(p mercury.PointInTime) mercury.Config {
+	m := make(mercury.SpaceMap)
+	for i, rev := range h.history {
+		if p.Revision > 0 && uint64(i+1) > p.Revision {
+			break
+		}
+		if p.Revision == 0 && rev.created.After(p.Time) {
+			break
+		}
+
+		if rev.value == nil {
+			delete(m, rev.space)
+			continue
+		}
+		m[rev.space] = rev.value
+	}
+
+	return m.ToArray()
+}
+
+// WriteConfig replaces the spaces. Empty spaces are removed.
+func (h *Handler) WriteConfig(ctx context.Context, config mercury.Config) error {
+	return h.WriteConfigIf(ctx, config, nil)
+}
+
+// WriteConfigIf replaces the spaces if check passes for the current version.
+func (h *Handler) WriteConfigIf(ctx context.Context, config mercury.Config, check func(current mercury.Config) error) error {
+	return h.WriteConfigNotify(ctx, config, check, nil)
+}
+
+// WriteConfigNotify replaces the spaces with copies if check passes and queues
+// the notifies with the write.
+func (h *Handler) WriteConfigNotify(ctx context.Context, config mercury.Config, check func(current mercury.Config) error, notify mercury.ListNotify) error {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if check != nil {
+		var current mercury.Config
+		for _, s := range config {
+			if c, ok := h.spaces[s.Space]; ok {
+				current = append(current, c)
+			}
+		}
+		if err := check(clone(current)); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, s := range config {
+		if len(s.Tags) == 0 && len(s.Notes) == 0 && len(s.List) == 0 {
+			delete(h.spaces, s.Space)
+			h.history = append(h.history, revision{now, s.Space, nil})
+			continue
+		}
+		s = s.Clone()
+		h.spaces[s.Space] = s
+		h.history = append(h.history, revision{now, s.Space, s})
+	}
+	h.queue(now, notify...)
+
+	return nil
+}
+
+type grouper interface {
+	GetGroups() []string
+}
+
+// GetRules returns the rules for the groups of the user. Groups are listed in
+// the space mercury.groups as `<group> :<user>` and the rules for each group
+// in mercury.policy as `<group> :<role> <type> <match>`.
+func (h *Handler) GetRules(ctx context.Context, user ident.Ident) (mercury.Rules, error) {
+	_, span := lg.Span(ctx)
+	defer span.End()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	groups := make(map[string]struct{})
+	if u, ok := user.(grouper); ok {
+		for _, g := range u.GetGroups() {
+			groups[g] = struct{}{}
+		}
+	}
+	if s, ok := h.spaces[mercuryGroups]; ok {
+		for _, v := range s.List {
+			for _, id := range v.Values {
+				if id == user.Identity() {
+					groups[v.Name] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var lis mercury.Rules
+	if s, ok := h.spaces[mercuryPolicy]; ok {
+		for _, v := range s.List {
+			if _, ok := groups[v.Name]; !ok {
+				continue
+			}
+			for _, rule := range v.Values {
+				var r mercury.Rule
+				r.Role, rule, _ = strings.Cut(rule, " ")
+				r.Type, r.Match, _ = strings.Cut(rule, " ")
+				lis = append(lis, r)
+			}
+		}
+	}
+
+	return lis, nil
+}
+
+// SendNotify records the notification.
+func (h *Handler) SendNotify(ctx context.Context, n mercury.Notify) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sent = append(h.sent, n)
+
+	return nil
+}
+
+// Sent returns the notifications sent in order.
+func (h *Handler) Sent() mercury.ListNotify {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return append(mercury.ListNotify(nil), h.sent...)
+}
+
+// WriteAudit records the audit entries.
+func (h *Handler) WriteAudit(ctx context.Context, lis ...mercury.Audit) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.audit = append(h.audit, lis...)
+
+	return nil
+}
+
+// ReadAudit returns the audit entries matching search newest first.
+func (h *Handler) ReadAudit(ctx context.Context, search mercury.AuditSearch) ([]mercury.Audit, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var lis []mercury.Audit
+	for _, a := range slices.Backward(h.audit) {
+		if !search.Match(a) {
+			continue
+		}
+		lis = append(lis, a)
+		if search.Limit > 0 && len(lis) >= search.Limit {
+			break
+		}
+	}
+
+	return lis, nil
+}
+
+// QueueNotify adds the notifies to the outbox.
+func (h *Handler) QueueNotify(ctx context.Context, notify ...mercury.Notify) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.queue(time.Now(), notify...)
+
+	return nil
+}
+
+func (h *Handler) queue(now time.Time, notify ...mercury.Notify) {
+	for _, n := range notify {
+		h.nextID++
+		h.outbox = append(h.outbox, mercury.OutboxItem{ID: h.nextID, Notify: n, Created: now, Next: now})
+	}
+}
+
+// DueOutbox returns the notifies due at now and holds them for lease.
+func (h *Handler) DueOutbox(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]mercury.OutboxItem, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var lis []mercury.OutboxItem
+	for i, item := range h.outbox {
+		if item.Dead || item.Next.After(now) {
+			continue
+		}
+		item.Sent = slices.Clone(item.Sent)
+		lis = append(lis, item)
+		h.outbox[i].Next = now.Add(lease)
+		if limit > 0 && len(lis) >= limit {
+			break
+		}
+	}
+
+	return lis, nil
+}
+
+// UpdateOutbox stores the outcome of a failed delivery.
+func (h *Handler) UpdateOutbox(ctx context.Context, item mercury.OutboxItem) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.outbox {
+		if h.outbox[i].ID == item.ID {
+			h.outbox[i] = item
+		}
+	}
+
+	return nil
+}
+
+// DeleteOutbox removes a delivered notify.
+func (h *Handler) DeleteOutbox(ctx context.Context, id uint64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.outbox = slices.DeleteFunc(h.outbox, func(item mercury.OutboxItem) bool { return item.ID == id })
+
+	return nil
+}
+
+// DeadOutbox returns the notifies that were given up on.
+func (h *Handler) DeadOutbox(ctx context.Context) ([]mercury.OutboxItem, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var lis []mercury.OutboxItem
+	for _, item := range h.outbox {
+		if item.Dead {
+			lis = append(lis, item)
+		}
+	}
+
+	return lis, nil
+}
+
+// Outbox returns the notifies waiting for delivery in order.
+func (h *Handler) Outbox() []mercury.OutboxItem {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return slices.Clone(h.outbox)
+}
@@ -0,0 +1,142 @@
+package mem_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+)
+
+func parse(t *testing.T, text string) mercury.Config {
+	t.Helper()
+
+	m, err := mercury.ParseText(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.ToArray()
+}
+
+func read(t *testing.T, h *mem.Handler, search string) string {
+	t.Helper()
+
+	lis, err := h.GetConfig(context.Background(), mercury.ParseSearch(search))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lis.EnvString()
+}
+
+func TestAt(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	seed := parse(t, "@app.a\nfoo :seed\n").ToSpaceMap()
+	h := mem.New(seed)
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	is.NoErr(h.WriteConfig(ctx, parse(t, "@app.a\nfoo :two\n")))
+	is.NoErr(h.WriteConfig(ctx, parse(t, "@app.b\nbar :one\n")))
+	is.NoErr(h.WriteConfig(ctx, mercury.Config{mercury.NewSpace("app.a")}))
+
+	is.Equal(read(t, h, "app.*"), "app.b:bar=one\n")
+	is.Equal(read(t, h, "app.* at 1"), "app.a:foo=seed\n")
+	is.Equal(read(t, h, "app.* at 2"), "app.a:foo=two\n")
+	is.Equal(read(t, h, "app.* at 3"), "app.a:foo=two\napp.b:bar=one\n")
+	is.Equal(read(t, h, "app.* at 4"), "app.b:bar=one\n")
+
+	// the seed is found at any time before the first write.
+	is.Equal(read(t, h, "app.* at "+before.Format(time.RFC3339Nano)), "app.a:foo=seed\n")
+	is.Equal(read(t, h, "app.* at 2000-01-01T00:00:00Z"), "app.a:foo=seed\n")
+}
+
+func TestClone(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	seed := parse(t, "@app.a\nfoo :seed\n").ToSpaceMap()
+	h := mem.New(seed)
+	seed["app.a"].List[0].Values[0] = "changed"
+	is.Equal(read(t, h, "app.a"), "app.a:foo=seed\n")
+
+	// spaces written or read are not shared with the caller.
+	lis := parse(t, "@app.a\nfoo :one\n")
+	is.NoErr(h.WriteConfig(ctx, lis))
+	lis[0].List[0].Values[0] = "changed"
+	is.Equal(read(t, h, "app.a"), "app.a:foo=one\n")
+
+	lis, err := h.GetConfig(ctx, mercury.ParseSearch("app.a"))
+	is.NoErr(err)
+	lis[0].List[0].Values[0] = "changed"
+	is.Equal(read(t, h, "app.a"), "app.a:foo=one\n")
+	is.Equal(read(t, h, "app.a at 2"), "app.a:foo=one\n")
+}
+
+func TestWriteConfigIf(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := mem.New(parse(t, "@app.a\nfoo :seed\n").ToSpaceMap())
+
+	// the check is called with the stored version of the spaces written.
+	var current mercury.Config
+	err := h.WriteConfigIf(ctx, parse(t, "@app.a\nfoo :one\n@app.b\nbar :one\n"), func(c mercury.Config) error {
+		current = c
+		return mercury.ErrPreconditionFailed
+	})
+	is.True(errors.Is(err, mercury.ErrPreconditionFailed))
+	is.Equal(current.EnvString(), "app.a:foo=seed\n")
+	is.Equal(read(t, h, "app.*"), "app.a:foo=seed\n")
+
+	err = h.WriteConfigIf(ctx, parse(t, "@app.a\nfoo :one\n"), func(c mercury.Config) error { return nil })
+	is.NoErr(err)
+	is.Equal(read(t, h, "app.*"), "app.a:foo=one\n")
+}
+
+func TestOutbox(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := mem.New(nil)
+	n := mercury.Notify{Name: "app", Match: "app.*", Event: "updated", Method: "POST", URL: "https://example.com/hook"}
+	is.NoErr(h.WriteConfigNotify(ctx, parse(t, "@app.a\nfoo :one\n"), nil, mercury.ListNotify{n}))
+	is.Equal(len(h.Outbox()), 1)
+
+	// a due notify is held for the lease.
+	now := time.Now()
+	lis, err := h.DueOutbox(ctx, now, 10, time.Minute)
+	is.NoErr(err)
+	is.Equal(len(lis), 1)
+	is.Equal(lis[0].Notify, n)
+
+	lis, err = h.DueOutbox(ctx, now, 10, time.Minute)
+	is.NoErr(err)
+	is.Equal(len(lis), 0)
+
+	lis, err = h.DueOutbox(ctx, now.Add(2*time.Minute), 10, time.Minute)
+	is.NoErr(err)
+	is.Equal(len(lis), 1)
+
+	// a dead notify is no longer due.
+	item := lis[0]
+	item.Attempts, item.Dead, item.Error = 1, true, "refused"
+	is.NoErr(h.UpdateOutbox(ctx, item))
+
+	lis, err = h.DueOutbox(ctx, now.Add(time.Hour), 10, time.Minute)
+	is.NoErr(err)
+	is.Equal(len(lis), 0)
+
+	dead, err := h.DeadOutbox(ctx)
+	is.NoErr(err)
+	is.Equal(len(dead), 1)
+	is.Equal(dead[0].Error, "refused")
+
+	is.NoErr(h.DeleteOutbox(ctx, item.ID))
+	is.Equal(len(h.Outbox()), 0)
+}
@@ -1,8 +1,14 @@
 package mercury
 
 import (
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/exp/maps"
@@ -60,6 +66,22 @@ func (lis Config) ToSpaceMap() SpaceMap {
 	return out
 }
 
+// Hash returns a content hash of the spaces in config.
+// The hash does not depend on the order of the spaces.
+func (lis Config) Hash() string {
+	hashes := make([]string, len(lis))
+	for i, s := range lis {
+		hashes[i] = s.Space + " " + s.Hash()
+	}
+	sort.Strings(hashes)
+
+	h := fnv.New128a()
+	for _, s := range hashes {
+		fmt.Fprintln(h, s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // String format config as string
 func (lis Config) String() string {
 
@@ -229,7 +251,7 @@ func (lis Config) INIString() string {
 				for i, s := range v.Values[1:] {
 					buf.WriteString(v.Name)
 					buf.WriteRune('[')
-					buf.WriteString(fmt.Sprintf("%d", i))
+					buf.WriteString(fmt.Sprintf("%d", i+1))
 					buf.WriteRune(']')
 					buf.WriteRune('=')
 					buf.WriteString(s)
@@ -249,6 +271,128 @@ func (lis Config) INIString() string {
 	return buf.String()
 }
 
+// YAMLString format config as yaml. Each space is a mapping of its keys and
+// keys with more than one value are lists. Notes are written as comments.
+func (lis Config) YAMLString() string {
+	var buf strings.Builder
+	for _, o := range lis {
+		for _, note := range o.Notes {
+			buf.WriteString("# ")
+			buf.WriteString(note)
+			buf.WriteRune('\n')
+		}
+		buf.WriteString(yamlKey(o.Space))
+		buf.WriteRune(':')
+		if len(o.List) == 0 {
+			buf.WriteString(" {}")
+		}
+		buf.WriteRune('\n')
+
+		var names []string
+		values := make(map[string][]string)
+		notes := make(map[string][]string)
+		for _, v := range o.List {
+			if _, ok := values[v.Name]; !ok {
+				names = append(names, v.Name)
+			}
+			values[v.Name] = append(values[v.Name], v.Values...)
+			notes[v.Name] = append(notes[v.Name], v.Notes...)
+		}
+
+		for _, name := range names {
+			for _, note := range notes[name] {
+				buf.WriteString("  # ")
+				buf.WriteString(note)
+				buf.WriteRune('\n')
+			}
+			buf.WriteString("  ")
+			buf.WriteString(yamlKey(name))
+			buf.WriteRune(':')
+
+			switch vs := values[name]; len(vs) {
+			case 0:
+				buf.WriteString(" \"\"\n")
+			case 1:
+				buf.WriteRune(' ')
+				buf.WriteString(strconv.Quote(vs[0]))
+				buf.WriteRune('\n')
+			default:
+				buf.WriteRune('\n')
+				for _, s := range vs {
+					buf.WriteString("    - ")
+					buf.WriteString(strconv.Quote(s))
+					buf.WriteRune('\n')
+				}
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*$`)
+
+// yamlKey quotes a key unless it is safe as a plain yaml scalar.
+func yamlKey(s string) string {
+	switch strings.ToLower(s) {
+	case "y", "n", "yes", "no", "on", "off", "true", "false", "null":
+		return strconv.Quote(s)
+	}
+	if yamlPlain.MatchString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// DotEnvString format config as a dotenv file. Keys are named by EnvName and
+// values are quoted for the shell. Keys with more than one value are joined
+// with newlines.
+func (lis Config) DotEnvString() string {
+	var buf strings.Builder
+	for _, o := range lis {
+		for _, v := range o.List {
+			buf.WriteString(EnvName(o.Space, v.Name))
+			buf.WriteRune('=')
+			buf.WriteString(shellQuote(v.Join()))
+			buf.WriteRune('\n')
+		}
+	}
+
+	return buf.String()
+}
+
+// EnvName maps a space and key onto an upper snake case variable name.
+// Letters and digits are kept and every other rune becomes an underscore,
+// so app.one:db-host is APP_ONE_DB_HOST. A name starting with a digit is
+// prefixed with an underscore.
+func EnvName(space, name string) string {
+	s := strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}, space+"_"+name)
+
+	if s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+	return s
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+
+// shellQuote single quotes a value unless it only holds runes the shell does
+// not interpret.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 // String format config as string
 func (lis Config) HTMLString() string {
 
@@ -355,12 +499,22 @@ type Space struct {
 	Notes   []string `json:"notes,omitempty"`
 	List    []Value  `json:"list,omitempty"`
 	Trailer []string `json:"trailer,omitempty"`
+
+	// Line is where the space was read from text, used in validation errors.
+	Line int `json:"-"`
 }
 
 func NewSpace(space string) *Space {
 	return &Space{Space: space}
 }
 
+// Hash returns a content hash of the space.
+func (s *Space) Hash() string {
+	h := fnv.New128a()
+	fmt.Fprint(h, Config{s}.String())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // HasTag returns true if needle is found
 // If the needle ends with a / it will be treated
 // as a prefix for tag meta data.
@@ -597,32 +751,144 @@ func (m SpaceMap) ToArray() Config {
 func (m *SpaceMap) MergeMap(s SpaceMap) {
 	m.Merge(maps.Values(s)...)
 }
+
+// Merge adds spaces that are not already in the map.
+// Only the first version of a space is kept based on priority.
 func (m *SpaceMap) Merge(lis ...*Space) {
+	m.MergeWith(MergeFirst, lis...)
+}
+
+// MergePolicy sets how a space is combined with a copy of the same space
+// that was already read from a higher priority source.
+type MergePolicy string
+
+const (
+	// MergeFirst keeps the higher priority copy and drops the rest.
+	MergeFirst MergePolicy = "first"
+	// MergeValues combines the values of keys with the same name.
+	MergeValues MergePolicy = "values"
+	// MergeAppend adds all keys after the keys already present.
+	MergeAppend MergePolicy = "append"
+	// MergeOverride adds keys that are not already present by name.
+	MergeOverride MergePolicy = "override"
+)
+
+// MergeTag sets the merge policy of a source. eg. merge/values
+const MergeTag = "merge"
+
+// ParseMergePolicy reads a merge policy as set by a `merge/<policy>` tag.
+func ParseMergePolicy(s string) (MergePolicy, error) {
+	switch MergePolicy(s) {
+	case MergeFirst:
+		return MergeFirst, nil
+	case MergeValues, "merge-values":
+		return MergeValues, nil
+	case MergeAppend:
+		return MergeAppend, nil
+	case MergeOverride, "override-by-key":
+		return MergeOverride, nil
+	}
+	return "", fmt.Errorf("unknown merge policy: %q", s)
+}
+
+// spaceMergePolicy reads the merge policy from the merge/<policy> tag of a
+// source. A source without the tag keeps the first copy of a space.
+func spaceMergePolicy(s *Space) (MergePolicy, error) {
+	if !s.HasTag(MergeTag) && !s.HasTag(MergeTag+"/") {
+		return MergeFirst, nil
+	}
+	policy, err := ParseMergePolicy(s.FirstTagMeta(MergeTag))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", s.Space, err)
+	}
+	return policy, nil
+}
+
+// MergeWith adds spaces to the map using policy for spaces that are already present.
+// Spaces already in the map are copied before they are changed.
+func (m *SpaceMap) MergeWith(policy MergePolicy, lis ...*Space) {
 	for _, s := range lis {
-		// Only accept first version based on priority.
-		if _, ok := (*m)[s.Space]; ok {
+		c, ok := (*m)[s.Space]
+		if !ok {
+			(*m)[s.Space] = s
 			continue
 		}
 
-		(*m)[s.Space] = s
+		switch policy {
+		case MergeValues:
+			c = c.Clone()
+			c.Tags = appendUnique(c.Tags, s.Tags...)
+			c.Notes = appendUnique(c.Notes, s.Notes...)
+			for _, v := range s.List {
+				if i := c.indexOf(v.Name); i >= 0 {
+					c.List[i].Values = appendUnique(c.List[i].Values, v.Values...)
+					c.List[i].Tags = appendUnique(c.List[i].Tags, v.Tags...)
+					continue
+				}
+				c.List = append(c.List, v)
+			}
+
+		case MergeAppend:
+			c = c.Clone()
+			c.Tags = appendUnique(c.Tags, s.Tags...)
+			c.Notes = append(c.Notes, s.Notes...)
+			c.List = append(c.List, s.List...)
+
+		case MergeOverride:
+			c = c.Clone()
+			c.Tags = appendUnique(c.Tags, s.Tags...)
+			for _, v := range s.List {
+				if c.indexOf(v.Name) < 0 {
+					c.List = append(c.List, v)
+				}
+			}
 
-		// // Merge values together.
-		// c, ok := (*m)[s.Space]
-		// if ok {
-		// 	c = &Space{}
-		// }
-		// c.Notes = append(c.Notes, s.Notes...)
-		// c.Tags = append(c.Tags, s.Tags...)
-		// last := c.List[len(c.List)-1].Seq
-		// for i := range s.List {
-		// 	v := s.List[i]
-		// 	v.Seq += last
-		// 	c.List = append(c.List, v)
-		// }
-		// (*m)[s.Space] = c
+		default:
+			continue
+		}
+
+		for i := range c.List {
+			c.List[i].Seq = uint64(i)
+		}
+		(*m)[s.Space] = c
 	}
 }
 
+// Clone returns a copy of the space that can be changed without changing s.
+func (s *Space) Clone() *Space {
+	c := *s
+	c.Tags = append([]string(nil), s.Tags...)
+	c.Notes = append([]string(nil), s.Notes...)
+	c.Trailer = append([]string(nil), s.Trailer...)
+	c.List = make([]Value, len(s.List))
+	for i, v := range s.List {
+		v.Values = append([]string(nil), v.Values...)
+		v.Tags = append([]string(nil), v.Tags...)
+		v.Notes = append([]string(nil), v.Notes...)
+		c.List[i] = v
+	}
+	return &c
+}
+
+// indexOf returns the position of the first value with name or -1.
+func (s *Space) indexOf(name string) int {
+	for i := range s.List {
+		if s.List[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func appendUnique(lis []string, add ...string) []string {
+	for _, a := range add {
+		if !slices.Contains(lis, a) {
+			lis = append(lis, a)
+		}
+	}
+	return lis
+}
+
 // Value stores the attributes for space values
 type Value struct {
 	Space  string   `json:"-" db:"space"`
@@ -631,6 +897,9 @@ type Value struct {
 	Values []string `json:"values"`
 	Notes  []string `json:"notes"`
 	Tags   []string `json:"tags"`
+
+	// Line is where the value was read from text, used in validation errors.
+	Line int `json:"-"`
 }
 
 // func (v *Value) ID() string {
@@ -0,0 +1,46 @@
+package mercury_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+)
+
+func TestMergeWith(t *testing.T) {
+	parse := func(t *testing.T, text string) *mercury.Space {
+		m, err := mercury.ParseText(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.ToArray()[0]
+	}
+
+	tests := []struct {
+		policy mercury.MergePolicy
+		want   string
+	}{
+		{mercury.MergeFirst, "app:host=db.prod\n"},
+		{mercury.MergeValues, "app:host+=db.prod\napp:host+=db.local\napp:port=5432\n"},
+		{mercury.MergeAppend, "app:host=db.prod\napp:host=db.local\napp:port=5432\n"},
+		{mercury.MergeOverride, "app:host=db.prod\napp:port=5432\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			is := is.New(t)
+
+			high := parse(t, "@app\nhost :db.prod\n")
+			low := parse(t, "@app\nhost :db.local\nport :5432\n")
+
+			m := make(mercury.SpaceMap)
+			m.MergeWith(tt.policy, high)
+			m.MergeWith(tt.policy, low)
+
+			s, _ := m.Space("app")
+			is.Equal(mercury.Config{s}.EnvString(), tt.want)
+			is.Equal(len(high.List), 1) // higher priority copy is not changed
+		})
+	}
+}
@@ -1,27 +1,371 @@
+// Package mqtt provides a mercury notify handler that publishes to an MQTT
+// 3.1.1 or 5 broker.
 package mqtt
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"go.sour.is/pkg/env"
 	"go.sour.is/pkg/lg"
 	"go.sour.is/pkg/mercury"
 )
 
-type mqttNotify struct{}
+// DefaultTimeout bounds a publish when the context has no deadline.
+var DefaultTimeout = 10 * time.Second
 
-func (mqttNotify) SendNotify(ctx context.Context, n mercury.Notify) {
-	_, span := lg.Span(ctx)
+type mqttNotify struct {
+	broker   string // host:port
+	tls      *tls.Config
+	version  byte
+	clientID string
+	username string
+	password string
+	qos      byte
+	retain   bool
+}
+
+var _ mercury.SendNotify = (*mqttNotify)(nil)
+
+// SendNotify publishes the payload of the notify as JSON to the topic that is
+// the path of its URL. Each publish opens a clean session that is closed
+// once the broker acknowledges the message at the configured QoS.
+func (h *mqttNotify) SendNotify(ctx context.Context, n mercury.Notify) error {
+	ctx, span := lg.Span(ctx)
 	defer span.End()
-	// var m mqtt.Message
-	// m, err = mqtt.NewMessage(n.URL, n)
-	// if err != nil {
-	// 	return
-	// }
-	// log.Debug(n)
-	// err = mqtt.Publish(m)
-	// return
+
+	topic, broker, ok := notifyTopic(n.URL)
+	if !ok {
+		err := fmt.Errorf("mqtt-notify: %s: not an mqtt url %q", n.Name, n.URL)
+		span.RecordError(err)
+		return err
+	}
+	if h.broker != "" {
+		broker = h.broker
+	}
+	if topic == "" || broker == "" {
+		err := fmt.Errorf("mqtt-notify: %s: missing broker or topic in %q", n.Name, n.URL)
+		span.RecordError(err)
+		return err
+	}
+
+	payload := n.Payload
+	if payload == nil {
+		payload = &mercury.NotifyPayload{Event: n.Event, Name: n.Name}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.AddEvent(fmt.Sprint("PUBLISH ", broker, " ", topic))
+	err = h.publish(ctx, broker, publish{
+		Version:  h.version,
+		Topic:    topic,
+		QoS:      h.qos,
+		Retain:   h.retain,
+		PacketID: 1,
+		Payload:  body,
+	})
+	if err != nil {
+		err = fmt.Errorf("mqtt-notify: %s %s: %w", broker, topic, err)
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// notifyTopic reads the topic and broker from a notify URL. It returns false
+// for URLs that are not for MQTT.
+//
+//	mqtt://broker:1883/mercury/app
+//	mqtt:///mercury/app
+func notifyTopic(s string) (topic, broker string, ok bool) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", false
+	}
+	port, useTLS, ok := scheme(u.Scheme)
+	if !ok {
+		return "", "", false
+	}
+	if u.Host != "" {
+		broker = u.Host
+		if u.Port() == "" {
+			broker = net.JoinHostPort(u.Hostname(), port)
+		}
+		if useTLS {
+			broker = "tls://" + broker
+		}
+	}
+	return strings.TrimPrefix(u.Path, "/"), broker, true
+}
+
+// scheme returns the default port of an MQTT URL scheme and if it uses TLS.
+func scheme(s string) (port string, useTLS bool, ok bool) {
+	switch s {
+	case "mqtt", "tcp":
+		return "1883", false, true
+	case "mqtts", "ssl", "tls":
+		return "8883", true, true
+	}
+	return "", false, false
+}
+
+// publish connects to the broker and sends the message.
+func (h *mqttNotify) publish(ctx context.Context, broker string, msg publish) error {
+	broker, useTLS := strings.CutPrefix(broker, "tls://")
+	if h.tls != nil && !useTLS {
+		return errTLS
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if useTLS {
+		cfg := h.tls
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		cfg = cfg.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(broker)
+		}
+		tc := tls.Client(conn, cfg)
+		if err = tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn = tc
+	}
+
+	// connections publish concurrently so each needs its own client id.
+	r := bufio.NewReader(conn)
+	err = writePacket(conn, connect{
+		Version:  h.version,
+		ClientID: fmt.Sprintf("%s-%08x", h.clientID, rand.Uint32()),
+		Username: h.username,
+		Password: h.password,
+	}.packet())
+	if err != nil {
+		return err
+	}
+	p, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if err = connackError(h.version, p); err != nil {
+		return err
+	}
+
+	if err = writePacket(conn, msg.packet()); err != nil {
+		return err
+	}
+	if err = h.await(conn, r, msg); err != nil {
+		return err
+	}
+
+	return writePacket(conn, packet{Type: typeDisconnect})
 }
 
+// await reads the acknowledgements for the QoS of the message.
+func (h *mqttNotify) await(conn net.Conn, r *bufio.Reader, msg publish) error {
+	var want []byte
+	switch msg.QoS {
+	case 1:
+		want = []byte{typePuback}
+	case 2:
+		want = []byte{typePubrec, typePubcomp}
+	}
+
+	for _, typ := range want {
+		p, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		if p.Type != typ {
+			return fmt.Errorf("mqtt: expected packet type %d, got %d", typ, p.Type)
+		}
+		id, reason, err := ackID(p)
+		if err != nil {
+			return err
+		}
+		if id != msg.PacketID {
+			return fmt.Errorf("mqtt: acknowledgement for packet %d, sent %d", id, msg.PacketID)
+		}
+		if reason >= 0x80 {
+			return fmt.Errorf("mqtt: publish refused: reason code 0x%02x", reason)
+		}
+		if typ == typePubrec {
+			if err = writePacket(conn, ack(typePubrel, id)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Register adds the MQTT notify handler to the registry. It is configured with:
+//
+//	@mercury.output.mqtt.<name>
+//	match     :<priority> mqtt|mqtts
+//	broker    :<mqtt|mqtts|tcp|tls>://<host>:<port>
+//	client_id :<client id prefix>
+//	username  :<user name>
+//	password  :<password>
+//	qos       :<0|1|2>
+//	retain    :<true|false>
+//	version   :<3.1.1|5>
+//	ca        :<PEM file of broker CAs>
+//	cert      :<PEM file of client certificate>
+//	key       :<PEM file of client key>
+//
+// The password defaults to MERCURY_MQTT_PASSWORD. If broker is not set the
+// host of each notify URL is used. Each connection adds a random suffix to
+// the client id. The ca and cert are only used with mqtts brokers. Notify
+// rules publish with:
+//
+//	app :app.* updated PUBLISH mqtt://broker:1883/mercury/app
+//
+// The handler is also registered as mqtt-notify.
 func Register() {
-	mercury.Registry.Register("mqtt-notify", func(s *mercury.Space) any { return &mqttNotify{} })
+	for _, name := range []string{"mqtt", "mqtt-notify"} {
+		mercury.Registry.Register(name, func(s *mercury.Space) any {
+			h, err := newNotify(s)
+			if err != nil {
+				return err
+			}
+			return h
+		})
+	}
+}
+
+func newNotify(s *mercury.Space) (*mqttNotify, error) {
+	h := &mqttNotify{
+		version:  Version311,
+		clientID: s.FirstValue("client_id").First(),
+		username: s.FirstValue("username").First(),
+		password: s.FirstValue("password").First(),
+	}
+	if h.clientID == "" {
+		host, _ := os.Hostname()
+		h.clientID = "mercury-" + host
+	}
+	if h.password == "" {
+		h.password = env.Secret("MERCURY_MQTT_PASSWORD", "").Secret()
+	}
+
+	if v := s.FirstValue("qos").First(); v != "" {
+		qos, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || qos > 2 {
+			return nil, fmt.Errorf("mqtt-notify: qos: expected 0, 1 or 2, got %q", v)
+		}
+		h.qos = byte(qos)
+	}
+	if v := s.FirstValue("retain").First(); v != "" {
+		var err error
+		if h.retain, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("mqtt-notify: retain: %w", err)
+		}
+	}
+	switch v := s.FirstValue("version").First(); v {
+	case "", "3.1.1", "4":
+	case "5":
+		h.version = Version5
+	default:
+		return nil, fmt.Errorf("mqtt-notify: version: expected 3.1.1 or 5, got %q", v)
+	}
+
+	var err error
+	if h.tls, err = tlsConfig(s); err != nil {
+		return nil, err
+	}
+
+	if broker := s.FirstValue("broker").First(); broker != "" {
+		u, err := url.Parse(broker)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt-notify: broker: %w", err)
+		}
+		port, useTLS, ok := scheme(u.Scheme)
+		if !ok || u.Host == "" {
+			return nil, fmt.Errorf("mqtt-notify: broker: expected mqtt://host:port, got %q", broker)
+		}
+		h.broker = u.Host
+		if u.Port() == "" {
+			h.broker = net.JoinHostPort(u.Hostname(), port)
+		}
+		if useTLS {
+			h.broker = "tls://" + h.broker
+		} else if h.tls != nil {
+			return nil, errTLS
+		}
+	}
+	if h.broker == "" {
+		log.Println("mqtt-notify: no broker set, using the host of each notify for", s.Space)
+	}
+
+	return h, nil
+}
+
+// errTLS is returned when a CA or client certificate is set for a broker that
+// does not use TLS.
+var errTLS = errors.New("mqtt-notify: ca and cert need an mqtts broker")
+
+// tlsConfig reads the CA and client certificate. It returns nil if neither
+// is set.
+func tlsConfig(s *mercury.Space) (*tls.Config, error) {
+	ca := s.FirstValue("ca").First()
+	cert := s.FirstValue("cert").First()
+	key := s.FirstValue("key").First()
+	if ca == "" && cert == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt-notify: ca: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("mqtt-notify: ca: no certificates found")
+		}
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt-notify: cert: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return cfg, nil
 }
@@ -0,0 +1,356 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mqtt"
+)
+
+func TestSendNotify(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	b := startBroker(t, "secret")
+	mqtt.Register()
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	payload := &mercury.NotifyPayload{
+		Event:    "updated",
+		Spaces:   []string{"app.one"},
+		Identity: "user",
+		Hash:     "abc123",
+	}
+	notify := mercury.ParseNotify(mercury.NewSpace("mercury.notify").AddKeys(
+		mercury.NewValue("app").SetValues("app.* updated PUBLISH mqtt:///mercury/app"),
+		mercury.NewValue("hook").SetValues("app.* updated POST http://example.com/hook"),
+	), "updated")
+	is.Equal(len(notify), 2)
+	n := notify[0]
+	n.Payload = payload
+
+	tests := []struct {
+		name    string
+		config  map[string]string
+		version byte
+		qos     byte
+		retain  bool
+	}{
+		{"v3.1.1 qos 0", map[string]string{}, 4, 0, false},
+		{"v3.1.1 qos 2 retain", map[string]string{"qos": "2", "retain": "true"}, 4, 2, true},
+		{"v5 qos 1", map[string]string{"qos": "1", "version": "5"}, 5, 1, false},
+		{"v5 qos 2", map[string]string{"qos": "2", "version": "5"}, 5, 2, false},
+	}
+	clientIDs := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			tt.config["client_id"] = "mercury-test"
+			tt.config["password"] = "secret"
+			is.NoErr(configure(b.addr, tt.config))
+
+			is.NoErr(mercury.Registry.SendNotify(ctx, n))
+			msg := b.next(t)
+			is.Equal(msg.version, tt.version)
+			is.True(strings.HasPrefix(msg.clientID, "mercury-test-"))
+			is.True(!clientIDs[msg.clientID])
+			clientIDs[msg.clientID] = true
+			is.Equal(msg.topic, "mercury/app")
+			is.Equal(msg.qos, tt.qos)
+			is.Equal(msg.retain, tt.retain)
+
+			var got mercury.NotifyPayload
+			is.NoErr(json.Unmarshal(msg.payload, &got))
+			is.Equal(got.Spaces, payload.Spaces)
+			is.Equal(got.Hash, "abc123")
+		})
+	}
+
+	// a notify for another scheme is not published.
+	err := mercury.Registry.SendNotify(ctx, notify[1])
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "not an mqtt url"))
+
+	// a refused connection is an error so delivery is retried.
+	is.NoErr(configure(b.addr, map[string]string{"password": "wrong"}))
+	err = mercury.Registry.SendNotify(ctx, n)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "bad user name or password"))
+
+	is.NoErr(configure(b.addr, map[string]string{"password": "wrong", "version": "5"}))
+	err = mercury.Registry.SendNotify(ctx, n)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "0x86"))
+}
+
+func TestRegisterConfig(t *testing.T) {
+	is := is.New(t)
+	mqtt.Register()
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	is.True(configure("localhost:1883", map[string]string{"qos": "3"}) != nil)
+	is.True(configure("localhost:1883", map[string]string{"version": "3"}) != nil)
+	is.True(configure("localhost:1883", map[string]string{"retain": "maybe"}) != nil)
+	is.NoErr(configure("localhost:1883", map[string]string{"version": "3.1.1"}))
+
+	// a CA is only used with TLS.
+	ca := writeCA(t)
+	is.True(configure("localhost:1883", map[string]string{"ca": ca}) != nil)
+
+	out := mercury.NewSpace("mercury.output.mqtt.test")
+	out.AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("broker").SetValues("mqtts://localhost"),
+		mercury.NewValue("ca").SetValues(ca),
+	)
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{out.Space: out}))
+
+	out = mercury.NewSpace("mercury.output.mqtt.test")
+	out.AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("ca").SetValues(ca),
+	)
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{out.Space: out}))
+	err := mercury.Registry.SendNotify(context.Background(), mercury.Notify{Name: "app", URL: "mqtt://localhost:1/mercury/app"})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "ca and cert need an mqtts broker"))
+}
+
+// writeCA writes a self signed CA certificate and returns the file.
+func writeCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mercury test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// configure replaces the registry with an mqtt output for the broker.
+func configure(addr string, config map[string]string) error {
+	out := mercury.NewSpace("mercury.output.mqtt.test")
+	out.AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("broker").SetValues("mqtt://"+addr),
+	)
+	for k, v := range config {
+		out.AddKeys(mercury.NewValue(k).SetValues(v))
+	}
+	return mercury.Registry.Configure(mercury.SpaceMap{out.Space: out})
+}
+
+// message is a publish received by the broker.
+type message struct {
+	version  byte
+	clientID string
+	topic    string
+	qos      byte
+	retain   bool
+	payload  []byte
+}
+
+// broker accepts connections with the password and records publishes.
+type broker struct {
+	addr     string
+	password string
+	msgs     chan message
+}
+
+func startBroker(t *testing.T, password string) *broker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	b := &broker{addr: ln.Addr().String(), password: password, msgs: make(chan message, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := b.serve(conn); err != nil && err != io.EOF {
+					t.Log("broker:", err)
+				}
+			}()
+		}
+	}()
+
+	return b
+}
+
+func (b *broker) next(t *testing.T) message {
+	t.Helper()
+	select {
+	case msg := <-b.msgs:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published")
+		return message{}
+	}
+}
+
+func (b *broker) serve(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	typ, _, body, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != 1 {
+		return fmt.Errorf("expected CONNECT, got %d", typ)
+	}
+	var msg message
+	name, body := readString(body)
+	if name != "MQTT" {
+		return fmt.Errorf("unexpected protocol %q", name)
+	}
+	msg.version, body = body[0], body[1:]
+	flags := body[0]
+	body = body[3:] // flags and keep alive
+	if msg.version == 5 {
+		body = skipProperties(body)
+	}
+	msg.clientID, body = readString(body)
+	var password string
+	if flags&0x80 != 0 {
+		_, body = readString(body)
+	}
+	if flags&0x40 != 0 {
+		password, _ = readString(body)
+	}
+
+	if password != b.password {
+		code := byte(4)
+		if msg.version == 5 {
+			code = 0x86
+		}
+		return writePacket(conn, 2, 0, connack(msg.version, code))
+	}
+	if err = writePacket(conn, 2, 0, connack(msg.version, 0)); err != nil {
+		return err
+	}
+
+	for {
+		typ, flags, body, err = readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 3: // PUBLISH
+			msg.qos, msg.retain = flags>>1&0x03, flags&0x01 != 0
+			msg.topic, body = readString(body)
+			var id []byte
+			if msg.qos > 0 {
+				id, body = body[:2], body[2:]
+			}
+			if msg.version == 5 {
+				body = skipProperties(body)
+			}
+			msg.payload = body
+
+			switch msg.qos {
+			case 1:
+				err = writePacket(conn, 4, 0, id)
+			case 2:
+				err = writePacket(conn, 5, 0, id)
+			}
+			if err != nil {
+				return err
+			}
+			if msg.qos < 2 {
+				b.msgs <- msg
+			}
+
+		case 6: // PUBREL
+			if err = writePacket(conn, 7, 0, body[:2]); err != nil {
+				return err
+			}
+			b.msgs <- msg
+
+		case 14: // DISCONNECT
+			return nil
+
+		default:
+			return fmt.Errorf("unexpected packet %d", typ)
+		}
+	}
+}
+
+func connack(version, code byte) []byte {
+	if version == 5 {
+		return []byte{0, code, 0}
+	}
+	return []byte{0, code}
+}
+
+func readPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return h >> 4, h & 0x0f, body, err
+}
+
+func writePacket(w io.Writer, typ, flags byte, body []byte) error {
+	b := binary.AppendUvarint([]byte{typ<<4 | flags}, uint64(len(body)))
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+func readString(b []byte) (string, []byte) {
+	n := binary.BigEndian.Uint16(b)
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+func skipProperties(b []byte) []byte {
+	n, l := binary.Uvarint(b)
+	return b[l+int(n):]
+}
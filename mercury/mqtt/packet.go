@@ -0,0 +1,187 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types used by the publisher.
+const (
+	typeConnect    byte = 1
+	typeConnack    byte = 2
+	typePublish    byte = 3
+	typePuback     byte = 4
+	typePubrec     byte = 5
+	typePubrel     byte = 6
+	typePubcomp    byte = 7
+	typeDisconnect byte = 14
+)
+
+// Protocol levels.
+const (
+	Version311 byte = 4
+	Version5   byte = 5
+)
+
+// maxRemaining is the largest remaining length a packet can encode.
+const maxRemaining = 268_435_455
+
+// packet is a control packet with its fixed header split out.
+type packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+// writePacket writes the fixed header and body of p.
+func writePacket(w io.Writer, p packet) error {
+	if len(p.Body) > maxRemaining {
+		return fmt.Errorf("mqtt: packet too large: %d bytes", len(p.Body))
+	}
+	buf := make([]byte, 0, 5+len(p.Body))
+	buf = append(buf, p.Type<<4|p.Flags&0x0f)
+	buf = binary.AppendUvarint(buf, uint64(len(p.Body)))
+	buf = append(buf, p.Body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readPacket reads the next packet from r.
+func readPacket(r *bufio.Reader) (packet, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return packet{}, err
+	}
+	if n > maxRemaining {
+		return packet{}, errors.New("mqtt: malformed remaining length")
+	}
+	p := packet{Type: b >> 4, Flags: b & 0x0f, Body: make([]byte, n)}
+	_, err = io.ReadFull(r, p.Body)
+	return p, err
+}
+
+// appendString appends a length prefixed string.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// connect is the CONNECT packet of a client.
+type connect struct {
+	Version   byte
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive uint16
+}
+
+func (c connect) packet() packet {
+	flags := byte(0x02) // clean session
+	if c.Username != "" {
+		flags |= 0x80
+	}
+	if c.Password != "" {
+		flags |= 0x40
+	}
+
+	b := appendString(nil, "MQTT")
+	b = append(b, c.Version, flags)
+	b = binary.BigEndian.AppendUint16(b, c.KeepAlive)
+	if c.Version == Version5 {
+		b = append(b, 0) // no properties
+	}
+	b = appendString(b, c.ClientID)
+	if c.Username != "" {
+		b = appendString(b, c.Username)
+	}
+	if c.Password != "" {
+		b = appendString(b, c.Password)
+	}
+
+	return packet{Type: typeConnect, Body: b}
+}
+
+// publish is a PUBLISH packet.
+type publish struct {
+	Version  byte
+	Topic    string
+	QoS      byte
+	Retain   bool
+	PacketID uint16
+	Payload  []byte
+}
+
+func (p publish) packet() packet {
+	flags := p.QoS << 1
+	if p.Retain {
+		flags |= 0x01
+	}
+
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = binary.BigEndian.AppendUint16(b, p.PacketID)
+	}
+	if p.Version == Version5 {
+		b = append(b, 0) // no properties
+	}
+	b = append(b, p.Payload...)
+
+	return packet{Type: typePublish, Flags: flags, Body: b}
+}
+
+// ack returns a PUBACK, PUBREC, PUBREL or PUBCOMP for the packet id.
+func ack(typ byte, id uint16) packet {
+	p := packet{Type: typ, Body: binary.BigEndian.AppendUint16(nil, id)}
+	if typ == typePubrel {
+		p.Flags = 0x02
+	}
+	return p
+}
+
+// ackID reads the packet id and reason code of an acknowledgement. Version
+// 3.1.1 acknowledgements have no reason code and are reported as success.
+func ackID(p packet) (id uint16, reason byte, err error) {
+	if len(p.Body) < 2 {
+		return 0, 0, errors.New("mqtt: malformed acknowledgement")
+	}
+	id = binary.BigEndian.Uint16(p.Body)
+	if len(p.Body) > 2 {
+		reason = p.Body[2]
+	}
+	return id, reason, nil
+}
+
+// connackError returns the error for a rejected connection.
+func connackError(version byte, p packet) error {
+	if p.Type != typeConnack || len(p.Body) < 2 {
+		return fmt.Errorf("mqtt: expected CONNACK, got packet type %d", p.Type)
+	}
+	code := p.Body[1]
+	if code == 0 {
+		return nil
+	}
+	if version == Version5 {
+		return fmt.Errorf("mqtt: connection refused: reason code 0x%02x", code)
+	}
+	switch code {
+	case 1:
+		return errors.New("mqtt: connection refused: unacceptable protocol version")
+	case 2:
+		return errors.New("mqtt: connection refused: identifier rejected")
+	case 3:
+		return errors.New("mqtt: connection refused: server unavailable")
+	case 4:
+		return errors.New("mqtt: connection refused: bad user name or password")
+	case 5:
+		return errors.New("mqtt: connection refused: not authorized")
+	default:
+		return fmt.Errorf("mqtt: connection refused: return code %d", code)
+	}
+}
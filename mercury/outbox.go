@@ -0,0 +1,259 @@
+package mercury
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/service"
+)
+
+var (
+	// OutboxAttempts is how many times delivery is tried before a notify is
+	// moved to the dead letters.
+	OutboxAttempts = 8
+	// OutboxBackoff is the wait after the first failed delivery. It doubles
+	// with each attempt up to OutboxMaxBackoff.
+	OutboxBackoff    = time.Minute
+	OutboxMaxBackoff = 6 * time.Hour
+	// OutboxBatch is the most notifies delivered from each outbox per run.
+	OutboxBatch = 100
+	// OutboxLease is how long a run holds the notifies it claimed from other
+	// workers. Notifies a run did not reach are claimed again once it ends.
+	// It must be longer than NotifyTimeout.
+	OutboxLease = 5 * time.Minute
+)
+
+// OutboxItem is a notify waiting in an outbox.
+type OutboxItem struct {
+	ID       uint64    `json:"id"`
+	Notify   Notify    `json:"notify"`
+	Created  time.Time `json:"created"`
+	Next     time.Time `json:"next"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Dead     bool      `json:"dead,omitempty"`
+	// Sent names the outputs that accepted the notify on an earlier
+	// attempt. They are not sent to again.
+	Sent []string `json:"sent,omitempty"`
+}
+
+// Outbox is implemented by sources that queue notifies for delivery.
+type Outbox interface {
+	// QueueNotify adds notifies due now.
+	QueueNotify(context.Context, ...Notify) error
+	// DueOutbox returns up to limit notifies that are due at now and not
+	// dead. They are held from other workers for lease or until updated.
+	DueOutbox(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxItem, error)
+	// UpdateOutbox stores the outcome of a failed delivery.
+	UpdateOutbox(context.Context, OutboxItem) error
+	// DeleteOutbox removes a delivered notify.
+	DeleteOutbox(ctx context.Context, id uint64) error
+	// DeadOutbox returns the notifies that were given up on.
+	DeadOutbox(context.Context) ([]OutboxItem, error)
+}
+
+// WriteConfigNotify is implemented by sources that queue notifies in their
+// outbox in the same transaction as the write.
+type WriteConfigNotify interface {
+	WriteConfigNotify(ctx context.Context, spaces Config, check func(current Config) error, notify ListNotify) error
+}
+
+// WriteConfigNotify writes the spaces and queues the notifies for delivery.
+// If check is set the write is conditional on the stored version of the
+// spaces. When a single source takes the write and implements
+// WriteConfigNotify the notifies are queued in the same transaction.
+// Otherwise they are queued in the first outbox after the write, or sent
+// directly if there is no outbox.
+func (r *registry) WriteConfigNotify(ctx context.Context, spaces Config, check func(current Config) error, notify ListNotify) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	if len(notify) > 0 {
+		ok, err := r.writeConfigNotify(ctx, spaces, check, notify)
+		if ok || err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if check != nil {
+		err = r.WriteConfigIf(ctx, spaces, check)
+	} else {
+		err = r.WriteConfig(ctx, spaces)
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.QueueNotify(ctx, notify...)
+}
+
+// writeConfigNotify writes with the notifies if a single source takes the
+// write and implements WriteConfigNotify. It returns false if it did not.
+func (r *registry) writeConfigNotify(ctx context.Context, spaces Config, check func(current Config) error, notify ListNotify) (bool, error) {
+	ms, done := r.acquire()
+	defer done()
+
+	var hdlr *matcher[WriteConfig]
+	for _, s := range spaces {
+		for i := range ms.writeConfig {
+			if ms.writeConfig[i].Match.Match(s.Space) {
+				if hdlr != nil && hdlr != &ms.writeConfig[i] {
+					return false, nil
+				}
+				hdlr = &ms.writeConfig[i]
+				break
+			}
+		}
+	}
+	if hdlr == nil {
+		return false, nil
+	}
+	w, ok := hdlr.Handler.(WriteConfigNotify)
+	if _, isOutbox := hdlr.Handler.(Outbox); !ok || !isOutbox {
+		return false, nil
+	}
+
+	sealed, err := r.seal(spaces)
+	if err != nil {
+		return true, err
+	}
+	var opened func(Config) error
+	if check != nil {
+		opened = func(current Config) error { return check(r.open(current)) }
+	}
+	err = w.WriteConfigNotify(ctx, sealed, opened, notify)
+	if err != nil {
+		return true, err
+	}
+	r.publish(spaces)
+
+	return true, r.reloadIfChanged(ctx, spaces)
+}
+
+// QueueNotify adds the notifies to the first outbox. Without an outbox they
+// are sent directly and failures are logged.
+func (r *registry) QueueNotify(ctx context.Context, notify ...Notify) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	if len(notify) == 0 {
+		return nil
+	}
+
+	ms, done := r.acquire()
+	defer done()
+
+	if len(ms.outbox) > 0 {
+		hdlr := ms.outbox[0]
+		span.AddEvent(fmt.Sprint("QUEUE NOTIFY", hdlr.Name, hdlr.Match))
+		return hdlr.Handler.QueueNotify(ctx, notify...)
+	}
+
+	for _, n := range notify {
+		if err := r.SendNotify(ctx, n); err != nil {
+			span.RecordError(err)
+			log.Println("notify:", n.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// DeliverOutbox sends the notifies that are due in each outbox. A failed
+// delivery is retried with exponential backoff until OutboxAttempts, then
+// it is kept as a dead letter. Outputs that accepted a notify are recorded
+// so a retry only sends to the ones that failed.
+//
+// The notifies are held for OutboxLease. No delivery is started once less
+// than NotifyTimeout of the lease is left, so a notify is not sent by two
+// workers at once.
+func (r *registry) DeliverOutbox(ctx context.Context, now time.Time) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	ms, done := r.acquire()
+	defer done()
+
+	var errs error
+	for _, hdlr := range ms.outbox {
+		span.AddEvent(fmt.Sprint("DELIVER OUTBOX", hdlr.Name, hdlr.Match))
+
+		until := time.Now().Add(OutboxLease - NotifyTimeout)
+		lis, err := hdlr.Handler.DueOutbox(ctx, now, OutboxBatch, OutboxLease)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("outbox %s: %w", hdlr.Name, err))
+			continue
+		}
+
+		for _, item := range lis {
+			if time.Now().After(until) || ctx.Err() != nil {
+				break
+			}
+
+			sent, err := r.sendNotify(ctx, item.Notify, item.Sent)
+			if ctx.Err() != nil {
+				// stopped while sending, it is sent again once the lease ends.
+				break
+			}
+			if err == nil {
+				err = hdlr.Handler.DeleteOutbox(ctx, item.ID)
+				errs = errors.Join(errs, err)
+				continue
+			}
+
+			item.Sent = append(item.Sent, sent...)
+			item.Attempts++
+			item.Error = err.Error()
+			item.Next = now.Add(outboxBackoff(item.Attempts))
+			item.Dead = item.Attempts >= OutboxAttempts
+			if item.Dead {
+				log.Println("outbox: giving up on", item.Notify.Name, item.ID, err)
+			}
+			errs = errors.Join(errs, hdlr.Handler.UpdateOutbox(ctx, item))
+		}
+	}
+	span.RecordError(errs)
+
+	return errs
+}
+
+// outboxBackoff returns the wait after a number of failed attempts.
+func outboxBackoff(attempts int) time.Duration {
+	d := OutboxBackoff
+	for i := 1; i < attempts && d < OutboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, OutboxMaxBackoff)
+}
+
+// DeadOutbox returns the dead letters of each outbox.
+func (r *registry) DeadOutbox(ctx context.Context) ([]OutboxItem, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	ms, done := r.acquire()
+	defer done()
+
+	var lis []OutboxItem
+	for _, hdlr := range ms.outbox {
+		dead, err := hdlr.Handler.DeadOutbox(ctx)
+		if err != nil {
+			return nil, err
+		}
+		lis = append(lis, dead...)
+	}
+
+	return lis, nil
+}
+
+// RunOutbox delivers the outbox every minute on the crontab of the harness.
+// It can be passed to service.Harness.Setup as an application.
+func (r *registry) RunOutbox(ctx context.Context, svc *service.Harness) error {
+	svc.NewCron("* * * * *", r.DeliverOutbox)
+	return nil
+}
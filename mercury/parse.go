@@ -2,12 +2,42 @@ package mercury
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
+// ParseError is a malformed line in the text format.
+type ParseError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// ParseText reads spaces in the text format written by Config.String.
+// Malformed lines are skipped.
 func ParseText(body io.Reader) (config SpaceMap, err error) {
+	return parseText(body, false)
+}
+
+// ParseTextStrict reads spaces like ParseText but returns a ParseError for
+// each malformed line, value before a space, duplicate space header and
+// unterminated trailer. The errors are joined in the order of the lines.
+func ParseTextStrict(body io.Reader) (config SpaceMap, err error) {
+	return parseText(body, true)
+}
+
+func parseText(body io.Reader, strict bool) (config SpaceMap, err error) {
 	config = make(SpaceMap)
 
 	var space string
@@ -15,9 +45,19 @@ func ParseText(body io.Reader) (config SpaceMap, err error) {
 	var tags []string
 	var notes []string
 	var seq uint64
+	var lineno int
+	var errs error
+	seen := make(map[string]int)
+
+	fail := func(col int, format string, args ...any) {
+		if strict {
+			errs = errors.Join(errs, &ParseError{lineno, col, fmt.Sprintf(format, args...)})
+		}
+	}
 
 	scanner := bufio.NewScanner(body)
 	for scanner.Scan() {
+		lineno++
 		line := scanner.Text()
 
 		if len(line) == 0 {
@@ -34,10 +74,20 @@ func ParseText(body io.Reader) (config SpaceMap, err error) {
 			var ok bool
 
 			sp := strings.Fields(strings.TrimPrefix(line, "@"))
+			if len(sp) == 0 {
+				fail(1, "space without a name")
+				continue
+			}
 			space = sp[0]
 
+			if first, ok := seen[space]; ok {
+				fail(2, "duplicate space %q, first at line %d", space, first)
+			} else {
+				seen[space] = lineno
+			}
+
 			if c, ok = config[space]; !ok {
-				c = &Space{Space: space}
+				c = &Space{Space: space, Line: lineno}
 			}
 
 			c.Notes = append(make([]string, 0, len(notes)), notes...)
@@ -52,37 +102,53 @@ func ParseText(body io.Reader) (config SpaceMap, err error) {
 
 		if strings.HasPrefix(line, "----") && strings.HasSuffix(line, "----") {
 			var trailer []string
+			var closed bool
+			start := lineno
 
 			trailer = append(trailer, line)
 			for scanner.Scan() {
+				lineno++
 				line = scanner.Text()
 				trailer = append(trailer, line)
 				if strings.HasPrefix(line, "----") && strings.HasSuffix(line, "----") {
+					closed = true
 					break
 				}
 			}
+			if !closed && strict {
+				errs = errors.Join(errs, &ParseError{start, 1, "unterminated trailer"})
+			}
+			if space == "" {
+				if strict {
+					errs = errors.Join(errs, &ParseError{start, 1, "trailer before a space"})
+				}
+				continue
+			}
 			c, ok := config[space]
 			if !ok {
 				c = &Space{Space: space}
 			}
-			log.Println(trailer)
 			c.Trailer = append(c.Trailer, trailer...)
 			config[space] = c
 			continue
 		}
-		if space == "" {
-			continue
-		}
 
 		sp := strings.SplitN(line, ":", 2)
 		if len(sp) < 2 {
+			fail(1, "expected name :value")
+			continue
+		}
+
+		if space == "" {
+			fail(1, "value before a space")
 			continue
 		}
 
 		if strings.TrimSpace(sp[0]) == "" {
 			c, ok := config[space]
-			if !ok {
-				c = &Space{Space: space}
+			if !ok || len(c.List) == 0 {
+				fail(len(sp[0])+1, "continued value before a name")
+				continue
 			}
 
 			c.List[len(c.List)-1].Values = append(c.List[len(c.List)-1].Values, sp[1])
@@ -111,6 +177,7 @@ func ParseText(body io.Reader) (config SpaceMap, err error) {
 				Tags:   append(make([]string, 0, len(tags)), tags...),
 				Notes:  append(make([]string, 0, len(notes)), notes...),
 				Values: []string{sp[1]},
+				Line:   lineno,
 			},
 		)
 		config[space] = c
@@ -122,6 +189,241 @@ func ParseText(body io.Reader) (config SpaceMap, err error) {
 	if err = scanner.Err(); err != nil {
 		return nil, err
 	}
+	if errs != nil {
+		return nil, errs
+	}
 
 	return
 }
+
+// ParseJSON reads spaces in the format written for application/json. Either a
+// list of spaces or a page with a list is accepted.
+func ParseJSON(body io.Reader) (config SpaceMap, err error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var lis Config
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		var p page
+		err = json.Unmarshal(b, &p)
+		lis = p.List
+	} else {
+		err = json.Unmarshal(b, &lis)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config = make(SpaceMap)
+	var seq uint64
+	for _, s := range lis {
+		if s == nil || s.Space == "" {
+			return nil, fmt.Errorf("json: space without a name")
+		}
+		for i := range s.List {
+			seq++
+			s.List[i].Seq = seq
+		}
+		config.MergeWith(MergeAppend, s)
+	}
+
+	return config, nil
+}
+
+// ParseTOML reads spaces from toml. Each table is a space named by its path and
+// holds keys that are not tables. Arrays are read as multiple values.
+//
+//	["app.one"]
+//	host = "db"
+//	peers = ["a", "b"]
+func ParseTOML(body io.Reader) (config SpaceMap, err error) {
+	var m map[string]any
+	md, err := toml.NewDecoder(body).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	config = make(SpaceMap)
+	var seq uint64
+	for _, key := range md.Keys() {
+		var v any = m
+		for _, k := range key {
+			v = v.(map[string]any)[k]
+		}
+		if _, ok := v.(map[string]any); ok {
+			continue
+		}
+		if len(key) < 2 {
+			return nil, fmt.Errorf("toml: key %s is not in a table", key)
+		}
+		if _, ok := v.([]map[string]any); ok {
+			return nil, fmt.Errorf("toml: array of tables %s is not supported", key)
+		}
+
+		space := strings.Join(key[:len(key)-1], ".")
+		c, ok := config[space]
+		if !ok {
+			c = &Space{Space: space}
+			config[space] = c
+		}
+
+		var values []string
+		if lis, ok := v.([]any); ok {
+			for _, v := range lis {
+				values = append(values, formatTOML(v))
+			}
+		} else {
+			values = append(values, formatTOML(v))
+		}
+
+		seq++
+		c.List = append(c.List, Value{Seq: seq, Name: key[len(key)-1], Values: values})
+	}
+
+	return config, nil
+}
+
+func formatTOML(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// ParseINI reads spaces in the format written by Config.INIString. Sections are
+// spaces and comments are notes of the section or key that follows. Keys with
+// an index such as name[0] are read as multiple values of one key.
+func ParseINI(body io.Reader) (config SpaceMap, err error) {
+	config = make(SpaceMap)
+
+	var c *Space
+	var notes []string
+	var seq uint64
+	var lineno int
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+
+		case line[0] == ';' || line[0] == '#':
+			notes = append(notes, strings.TrimSpace(line[1:]))
+			continue
+
+		case line[0] == '[':
+			space, ok := strings.CutSuffix(line[1:], "]")
+			if !ok || strings.TrimSpace(space) == "" {
+				return nil, fmt.Errorf("ini: line %d: bad section %q", lineno, line)
+			}
+			space = strings.TrimSpace(space)
+
+			if c, ok = config[space]; !ok {
+				c = &Space{Space: space}
+				config[space] = c
+			}
+			c.Notes = append(c.Notes, notes...)
+			notes = notes[:0]
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("ini: line %d: expected key=value", lineno)
+		}
+		if c == nil {
+			return nil, fmt.Errorf("ini: line %d: key outside of a section", lineno)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		name, index, multi := strings.Cut(name, "[")
+		if multi && index != "0]" {
+			if i := len(c.List) - 1; i >= 0 && c.List[i].Name == name {
+				c.List[i].Values = append(c.List[i].Values, value)
+				c.List[i].Notes = append(c.List[i].Notes, notes...)
+				notes = notes[:0]
+				continue
+			}
+		}
+
+		seq++
+		c.List = append(c.List, Value{
+			Seq:    seq,
+			Name:   name,
+			Notes:  append(make([]string, 0, len(notes)), notes...),
+			Values: []string{value},
+		})
+		notes = notes[:0]
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ParseEnviron reads spaces in the format written by Config.EnvString. Each
+// line is space tags:name tags=value and name+= appends to the key above it.
+func ParseEnviron(body io.Reader) (config SpaceMap, err error) {
+	config = make(SpaceMap)
+
+	var seq uint64
+	var lineno int
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("environ: line %d: expected space:name=value", lineno)
+		}
+		key, add := strings.CutSuffix(key, "+")
+
+		left, right, ok := strings.Cut(key, ":")
+		spaceFields, nameFields := strings.Fields(left), strings.Fields(right)
+		if !ok || len(spaceFields) == 0 || len(nameFields) == 0 {
+			return nil, fmt.Errorf("environ: line %d: expected space:name=value", lineno)
+		}
+		space, name := spaceFields[0], nameFields[0]
+
+		c, ok := config[space]
+		if !ok {
+			c = &Space{Space: space}
+			config[space] = c
+		}
+		c.Tags = appendUnique(c.Tags, spaceFields[1:]...)
+
+		if i := len(c.List) - 1; add && i >= 0 && c.List[i].Name == name && len(nameFields) == 1 {
+			c.List[i].Values = append(c.List[i].Values, value)
+			continue
+		}
+
+		seq++
+		c.List = append(c.List, Value{
+			Seq:    seq,
+			Name:   name,
+			Tags:   append(make([]string, 0, len(nameFields)-1), nameFields[1:]...),
+			Values: []string{value},
+		})
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -1,6 +1,10 @@
 package mercury_test
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
 	"strings"
 	"testing"
 
@@ -26,3 +30,188 @@ acrdDxmq2S+oysQbK+pMUE
 	}
 
 }
+
+const testFormats = `
+# application settings
+@app.one readonly
+# the primary host
+host region/eu :db.example.com
+peers          :a
+               :b
+port           :5432
+
+@app.two
+path :/var/lib/app
+`
+
+func TestParseFormats(t *testing.T) {
+	sm, err := mercury.ParseText(strings.NewReader(testFormats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sm.ToArray()
+	sort.Sort(want)
+
+	tests := []struct {
+		name   string
+		format func(mercury.Config) string
+		parse  func(io.Reader) (mercury.SpaceMap, error)
+		strip  func(*mercury.Space)
+	}{
+		{"text", mercury.Config.String, mercury.ParseText, nil},
+		{"json", func(lis mercury.Config) string {
+			b, _ := json.Marshal(lis)
+			return string(b)
+		}, mercury.ParseJSON, nil},
+		{"ini", mercury.Config.INIString, mercury.ParseINI, func(s *mercury.Space) {
+			s.Tags = nil
+			for i := range s.List {
+				s.List[i].Tags = nil
+			}
+		}},
+		{"environ", mercury.Config.EnvString, mercury.ParseEnviron, func(s *mercury.Space) {
+			s.Notes = nil
+			for i := range s.List {
+				s.List[i].Notes = nil
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			m, err := tt.parse(strings.NewReader(tt.format(want)))
+			is.NoErr(err)
+
+			got := m.ToArray()
+			sort.Sort(got)
+
+			expect := make(mercury.Config, len(want))
+			for i, s := range want {
+				c := *s
+				c.List = append([]mercury.Value(nil), s.List...)
+				if tt.strip != nil {
+					tt.strip(&c)
+				}
+				expect[i] = &c
+			}
+			is.Equal(got.String(), expect.String())
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	is := is.New(t)
+
+	m, err := mercury.ParseTOML(strings.NewReader(`
+["app.one"]
+host = "db.example.com"
+port = 5432
+peers = ["a", "b"]
+
+[database.primary]
+enabled = true
+ratio = 0.5
+`))
+	is.NoErr(err)
+
+	lis := m.ToArray()
+	sort.Sort(lis)
+	is.Equal(lis.EnvString(), strings.Join([]string{
+		"app.one:host=db.example.com",
+		"app.one:port=5432",
+		"app.one:peers+=a",
+		"app.one:peers+=b",
+		"database.primary:enabled=true",
+		"database.primary:ratio=0.5",
+		"",
+	}, "\n"))
+
+	_, err = mercury.ParseTOML(strings.NewReader("host = \"db\"\n"))
+	is.True(err != nil)
+}
+
+func TestParseINIErrors(t *testing.T) {
+	is := is.New(t)
+
+	_, err := mercury.ParseINI(strings.NewReader("host=db\n"))
+	is.Equal(err.Error(), "ini: line 1: key outside of a section")
+
+	_, err = mercury.ParseINI(strings.NewReader("[app]\nhost\n"))
+	is.Equal(err.Error(), "ini: line 2: expected key=value")
+
+	_, err = mercury.ParseEnviron(strings.NewReader("app.host=db\n"))
+	is.Equal(err.Error(), "environ: line 1: expected space:name=value")
+}
+
+func TestFormatYAMLDotEnv(t *testing.T) {
+	is := is.New(t)
+
+	sm, err := mercury.ParseText(strings.NewReader(testFormats + "\n@3rd-party\nyes :it's here\n"))
+	is.NoErr(err)
+	lis := sm.ToArray()
+	sort.Sort(lis)
+
+	is.Equal(lis.YAMLString(), `"3rd-party":
+  "yes": "it's here"
+# application settings
+app.one:
+  # the primary host
+  host: "db.example.com"
+  peers:
+    - "a"
+    - "b"
+  port: "5432"
+app.two:
+  path: "/var/lib/app"
+`)
+
+	is.Equal(lis.DotEnvString(), `_3RD_PARTY_YES='it'\''s here'
+APP_ONE_HOST=db.example.com
+APP_ONE_PEERS='a
+b'
+APP_ONE_PORT=5432
+APP_TWO_PATH=/var/lib/app
+`)
+
+	is.Equal(mercury.EnvName("app.one", "db-host"), "APP_ONE_DB_HOST")
+}
+
+func TestParseTextStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"valid", "# note\n@app tag\nhost :db\n     :db2\n----BEGIN----\nsig\n----END----\n", ""},
+		{"before space", "host :db\n@app\n", "line 1:1: value before a space"},
+		{"no value", "@app\nhost\n", "line 2:1: expected name :value"},
+		{"continuation", "@app\n     :db\n", "line 2:6: continued value before a name"},
+		{"duplicate", "@app\nhost :db\n\n@app\n", `line 4:2: duplicate space "app", first at line 1`},
+		{"empty space", "@\n", "line 1:1: space without a name"},
+		{"trailer", "@app\n----BEGIN----\nsig\n", "line 2:1: unterminated trailer"},
+		{"many", "@app\nhost\n     :x\n", "line 2:1: expected name :value\nline 3:6: continued value before a name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := mercury.ParseTextStrict(strings.NewReader(tt.in))
+			if tt.want == "" {
+				is.NoErr(err)
+				return
+			}
+			is.True(err != nil)
+			is.Equal(err.Error(), tt.want)
+
+			var perr *mercury.ParseError
+			is.True(errors.As(err, &perr))
+
+			// the lenient parser skips the same lines.
+			_, err = mercury.ParseText(strings.NewReader(tt.in))
+			is.NoErr(err)
+		})
+	}
+}
@@ -2,12 +2,19 @@ package mercury
 
 import (
 	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"go.sour.is/pkg/ident"
 	"go.sour.is/pkg/lg"
@@ -24,6 +31,19 @@ type GetConfig interface {
 type WriteConfig interface {
 	WriteConfig(context.Context, Config) error
 }
+
+// Paged is implemented by sources that apply the Offset and Count of a
+// search themselves. The registry pages the results of other sources.
+type Paged interface {
+	Paged() bool
+}
+
+// WriteConfigIf is implemented by backends that can compare and swap inside
+// their write transaction. The check is called with the currently stored
+// version of the spaces being written and aborts the write if it errors.
+type WriteConfigIf interface {
+	WriteConfigIf(ctx context.Context, config Config, check func(current Config) error) error
+}
 type GetRules interface {
 	GetRules(context.Context, ident.Ident) (Rules, error)
 }
@@ -40,6 +60,8 @@ type SendNotify interface {
 // func (nobody) Identity() string         { return "xuu" }
 // func (nobody) HasRole(r ...string) bool { return true }
 
+// accessFilter returns the spaces the user can read with secret values
+// redacted unless the user has the reveal role.
 func (reg *registry) accessFilter(rules Rules, lis Config) (out Config, err error) {
 	accessList := make(map[string]struct{})
 	for _, o := range lis {
@@ -54,14 +76,16 @@ func (reg *registry) accessFilter(rules Rules, lis Config) (out Config, err erro
 		}
 	}
 
-	return
+	return out.Redact(rules), nil
 }
 
 // HandlerItem a single handler matching
 type matcher[T any] struct {
 	Name     string
+	Space    string // the space an output was configured from.
 	Match    Search
 	Priority int
+	Merge    MergePolicy
 	Handler  T
 }
 type matchers struct {
@@ -71,12 +95,28 @@ type matchers struct {
 	getRules    []matcher[GetRules]
 	getNotify   []matcher[GetNotify]
 	sendNotify  []matcher[SendNotify]
+	writeAudit  []matcher[WriteAudit]
+	readAudit   []matcher[ReadAudit]
+	outbox      []matcher[Outbox]
+
+	// handlers built for this set, closed once it is replaced and drained.
+	handlers []any
+	// active counts requests using this set.
+	active sync.WaitGroup
 }
 
 // registry a list of handlers
 type registry struct {
 	handlers map[string]func(*Space) any
-	matchers matchers
+	watchers watchers
+
+	mu       sync.RWMutex
+	matchers *matchers
+	base     SpaceMap
+
+	secretMu  sync.Mutex
+	secret    cipher.AEAD
+	secretSet bool
 }
 
 func (m matcher[T]) String() string {
@@ -86,7 +126,7 @@ func (m matcher[T]) String() string {
 // Registry handler
 var Registry *registry = &registry{}
 
-func (r registry) String() string {
+func (r *registry) String() string {
 	var buf strings.Builder
 	for h := range r.handlers {
 		buf.WriteString(h)
@@ -96,22 +136,46 @@ func (r registry) String() string {
 	return buf.String()
 }
 
-func (r *registry) resetMatchers() {
-	r.matchers.getIndex = r.matchers.getIndex[:0]
-	r.matchers.getConfig = r.matchers.getConfig[:0]
-	r.matchers.writeConfig = r.matchers.writeConfig[:0]
-	r.matchers.getRules = r.matchers.getRules[:0]
-	r.matchers.getNotify = r.matchers.getNotify[:0]
-	r.matchers.sendNotify = r.matchers.sendNotify[:0]
+// acquire returns the active matchers. The returned func must be called
+// when the request is done so replaced handlers can be drained.
+func (r *registry) acquire() (*matchers, func()) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m := r.matchers
+	if m == nil {
+		m = &matchers{}
+	}
+	m.active.Add(1)
+
+	return m, m.active.Done
+}
+
+func (m *matchers) sort() {
+	sort.Slice(m.getConfig, func(i, j int) bool { return m.getConfig[i].Priority < m.getConfig[j].Priority })
+	sort.Slice(m.getIndex, func(i, j int) bool { return m.getIndex[i].Priority < m.getIndex[j].Priority })
+	sort.Slice(m.writeConfig, func(i, j int) bool { return m.writeConfig[i].Priority < m.writeConfig[j].Priority })
+	sort.Slice(m.getRules, func(i, j int) bool { return m.getRules[i].Priority < m.getRules[j].Priority })
+	sort.Slice(m.getNotify, func(i, j int) bool { return m.getNotify[i].Priority < m.getNotify[j].Priority })
+	sort.Slice(m.sendNotify, func(i, j int) bool { return m.sendNotify[i].Priority < m.sendNotify[j].Priority })
+	sort.Slice(m.writeAudit, func(i, j int) bool { return m.writeAudit[i].Priority < m.writeAudit[j].Priority })
+	sort.Slice(m.readAudit, func(i, j int) bool { return m.readAudit[i].Priority < m.readAudit[j].Priority })
+	sort.Slice(m.outbox, func(i, j int) bool { return m.outbox[i].Priority < m.outbox[j].Priority })
 }
-func (r *registry) sortMatchers() {
-	sort.Slice(r.matchers.getConfig, func(i, j int) bool { return r.matchers.getConfig[i].Priority < r.matchers.getConfig[j].Priority })
-	sort.Slice(r.matchers.getIndex, func(i, j int) bool { return r.matchers.getIndex[i].Priority < r.matchers.getIndex[j].Priority })
-	sort.Slice(r.matchers.writeConfig, func(i, j int) bool { return r.matchers.writeConfig[i].Priority < r.matchers.writeConfig[j].Priority })
-	sort.Slice(r.matchers.getRules, func(i, j int) bool { return r.matchers.getRules[i].Priority < r.matchers.getRules[j].Priority })
-	sort.Slice(r.matchers.getNotify, func(i, j int) bool { return r.matchers.getNotify[i].Priority < r.matchers.getNotify[j].Priority })
-	sort.Slice(r.matchers.sendNotify, func(i, j int) bool { return r.matchers.sendNotify[i].Priority < r.matchers.sendNotify[j].Priority })
+
+// close waits for active requests to finish and closes the handlers.
+func (m *matchers) close() error {
+	m.active.Wait()
+
+	var errs error
+	for _, hdlr := range m.handlers {
+		if c, ok := hdlr.(io.Closer); ok {
+			errs = errors.Join(errs, c.Close())
+		}
+	}
+	return errs
 }
+
 func (r *registry) Register(name string, h func(*Space) any) {
 	if r.handlers == nil {
 		r.handlers = make(map[string]func(*Space) any)
@@ -119,188 +183,346 @@ func (r *registry) Register(name string, h func(*Space) any) {
 	r.handlers[name] = h
 }
 
+// sourceSearch matches the spaces that configure the registry.
+const sourceSearch = "mercury.source.*|mercury.output.*"
+
+// Configure builds the handlers for the source and output spaces in m and
+// swaps them in for the current set. The current set is drained and closed
+// in the background. If any handler fails to build the current set is kept.
 func (r *registry) Configure(m SpaceMap) error {
-	r.resetMatchers()
+	err := r.configure(m)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.base = m
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Reload reads the source and output spaces through the registry and
+// reconfigures it. Spaces read take priority over the ones passed to Configure.
+func (r *registry) Reload(ctx context.Context) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	lis, err := r.GetConfig(ctx, ParseSearch(sourceSearch))
+	if err != nil {
+		return err
+	}
+
+	m := lis.ToSpaceMap()
+	r.mu.RLock()
+	m.MergeMap(r.base)
+	r.mu.RUnlock()
+
+	err = r.configure(m)
+	span.RecordError(err)
+
+	return err
+}
+
+func (r *registry) configure(m SpaceMap) (err error) {
+	next := &matchers{}
+	defer func() {
+		if err != nil {
+			log.Println("configure: rollback: ", err)
+			next.close()
+		}
+	}()
+
 	for space, c := range m {
 		log.Println("configure: ", space)
 
-		if strings.HasPrefix(space, "mercury.source.") {
+		var handler, name string
+		var readonly bool
+		switch {
+		case strings.HasPrefix(space, "mercury.source."):
 			space = strings.TrimPrefix(space, "mercury.source.")
-			handler, name, _ := strings.Cut(space, ".")
-			matches := c.FirstValue("match")
-			readonly := c.HasTag("readonly")
-			for _, match := range matches.Values {
-				ps := strings.Fields(match)
-				priority, err := strconv.Atoi(ps[0])
-				if err != nil {
-					return err
-				}
-				err = r.add(name, handler, strings.Join(ps[1:],"|"), priority, c, readonly)
-				if err != nil {
-					return err
-				}
-			}
-		}
+			handler, name, _ = strings.Cut(space, ".")
+			readonly = c.HasTag("readonly")
 
-		if strings.HasPrefix(space, "mercury.output.") {
+		case strings.HasPrefix(space, "mercury.output."):
 			space = strings.TrimPrefix(space, "mercury.output.")
-			handler, name, _ := strings.Cut(space, ".")
-			matches := c.FirstValue("match")
-			for _, match := range matches.Values {
-				ps := strings.Fields(match)
-				priority, err := strconv.Atoi(ps[0])
-				if err != nil {
-					return err
-				}
-				err = r.add(name, handler, strings.Join(ps[1:],"|"), priority, c, false)
-				if err != nil {
-					return err
-				}
+			handler, name, _ = strings.Cut(space, ".")
+
+		default:
+			continue
+		}
+
+		hdlr, err := r.build(name, handler, c)
+		if err != nil {
+			return err
+		}
+		next.handlers = append(next.handlers, hdlr)
+
+		matches := c.FirstValue("match")
+		for _, match := range matches.Values {
+			ps := strings.Fields(match)
+			priority, err := strconv.Atoi(ps[0])
+			if err != nil {
+				return err
+			}
+			err = next.add(name, hdlr, strings.Join(ps[1:], "|"), priority, c, readonly)
+			if err != nil {
+				return err
 			}
 		}
 	}
 
-	r.sortMatchers()
+	next.sort()
+
+	r.mu.Lock()
+	prev := r.matchers
+	r.matchers = next
+	r.mu.Unlock()
+
+	if prev != nil {
+		go func() {
+			if err := prev.close(); err != nil {
+				log.Println("configure: close: ", err)
+			}
+		}()
+	}
+
 	return nil
 }
 
-// Register add a handler to registry
-func (r *registry) add(name, handler, match string, priority int, cfg *Space, readonly bool) error {
-	log.Println("mercury regster", "match", match, "pri", priority)
+// build creates a handler from its registered constructor.
+func (r *registry) build(name, handler string, cfg *Space) (any, error) {
 	mkHandler, ok := r.handlers[handler]
 	if !ok {
-		return fmt.Errorf("handler not registered: %s", handler)
+		return nil, fmt.Errorf("handler not registered: %s", handler)
 	}
 	hdlr := mkHandler(cfg)
 	if err, ok := hdlr.(error); ok {
-		return fmt.Errorf("%w: failed to config %s as handler: %s", err, name, handler)
+		return nil, fmt.Errorf("%w: failed to config %s as handler: %s", err, name, handler)
 	}
 	if hdlr == nil {
-		return fmt.Errorf("failed to config %s as handler: %s", name, handler)
+		return nil, fmt.Errorf("failed to config %s as handler: %s", name, handler)
 	}
 
+	return hdlr, nil
+}
+
+// add a handler to the matchers
+func (m *matchers) add(name string, hdlr any, match string, priority int, cfg *Space, readonly bool) error {
+	log.Println("mercury regster", "match", match, "pri", priority)
+
 	if hdlr, ok := hdlr.(GetIndex); ok {
-		r.matchers.getIndex = append(
-			r.matchers.getIndex,
+		m.getIndex = append(
+			m.getIndex,
 			matcher[GetIndex]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
 		)
 	}
 	if hdlr, ok := hdlr.(GetConfig); ok {
-		r.matchers.getConfig = append(
-			r.matchers.getConfig,
-			matcher[GetConfig]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
+		// The merge policy sets how spaces from this source combine with
+		// higher priority sources. eg. @mercury.source.sql.default merge/values
+		merge, err := spaceMergePolicy(cfg)
+		if err != nil {
+			return err
+		}
+		m.getConfig = append(
+			m.getConfig,
+			matcher[GetConfig]{Name: name, Match: ParseSearch(match), Priority: priority, Merge: merge, Handler: hdlr},
 		)
 	}
 
 	if hdlr, ok := hdlr.(WriteConfig); !readonly && ok {
 
-		r.matchers.writeConfig = append(
-			r.matchers.writeConfig,
+		m.writeConfig = append(
+			m.writeConfig,
 			matcher[WriteConfig]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
 		)
 	}
 	if hdlr, ok := hdlr.(GetRules); ok {
-		r.matchers.getRules = append(
-			r.matchers.getRules,
+		m.getRules = append(
+			m.getRules,
 			matcher[GetRules]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
 		)
 	}
 	if hdlr, ok := hdlr.(GetNotify); ok {
-		r.matchers.getNotify = append(
-			r.matchers.getNotify,
+		m.getNotify = append(
+			m.getNotify,
 			matcher[GetNotify]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
 		)
 	}
 	if hdlr, ok := hdlr.(SendNotify); ok {
-		r.matchers.sendNotify = append(
-			r.matchers.sendNotify,
-			matcher[SendNotify]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
+		m.sendNotify = append(
+			m.sendNotify,
+			matcher[SendNotify]{Name: name, Space: cfg.Space, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
+		)
+	}
+	if hdlr, ok := hdlr.(Outbox); !readonly && ok {
+		m.outbox = append(
+			m.outbox,
+			matcher[Outbox]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
+		)
+	}
+	if hdlr, ok := hdlr.(WriteAudit); !readonly && ok {
+		m.writeAudit = append(
+			m.writeAudit,
+			matcher[WriteAudit]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
+		)
+	}
+	if hdlr, ok := hdlr.(ReadAudit); ok {
+		m.readAudit = append(
+			m.readAudit,
+			matcher[ReadAudit]{Name: name, Match: ParseSearch(match), Priority: priority, Handler: hdlr},
 		)
 	}
 
 	return nil
 }
 
-func getMatches(search Search, matchers matchers) []Search {
-	matches := make([]Search, len(matchers.getIndex))
+// key identifies the matcher within a cursor.
+func (m matcher[T]) key() string {
+	return fmt.Sprintf("%s:%d", m.Name, m.Priority)
+}
+
+func getMatches[T any](search Search, matchers []matcher[T]) ([]Search, []string) {
+	matches := make([]Search, len(matchers))
+	keys := make([]string, len(matchers))
+
+	cur := decodeCursor(search.Cursor)
+	for i, hdlr := range matchers {
+		keys[i] = hdlr.key()
+		if cur[keys[i]] == cursorDone {
+			continue
+		}
 
-	for _, n := range search.NamespaceSearch {
-		for i, hdlr := range matchers.getIndex {
+		for _, n := range search.NamespaceSearch {
 			if hdlr.Match.Match(n.Raw()) {
 				matches[i].NamespaceSearch = append(matches[i].NamespaceSearch, n)
 				matches[i].Count = search.Count
-				matches[i].Cursor = search.Cursor // need to decode cursor for the match
+				matches[i].Offset = search.Offset
+				if search.Cursor != "" {
+					matches[i].Offset = uint64(cur[keys[i]])
+				}
 				matches[i].Fields = search.Fields
 				matches[i].Find = search.Find
+				matches[i].At = search.At
 			}
 		}
 	}
-	return matches
+	return matches, keys
 }
 
 // GetIndex query each handler that match namespace.
-func (r *registry) GetIndex(ctx context.Context, search Search) (c Config, err error) {
+func (r *registry) GetIndex(ctx context.Context, search Search) (Config, error) {
+	c, _, err := r.GetIndexPage(ctx, search)
+	return c, err
+}
+
+// GetIndexPage query each handler that match namespace.
+// If the search has a count the cursor to the next page is returned.
+func (r *registry) GetIndexPage(ctx context.Context, search Search) (Config, string, error) {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
-	matches := getMatches(search, r.matchers)
+	if search.Err != nil {
+		span.RecordError(search.Err)
+		return nil, "", search.Err
+	}
 
-	wg, ctx := errgroup.WithContext(ctx)
-	slots := make(chan Config, len(r.matchers.getConfig))
-	wg.Go(func() error {
-		i := 0
-		for lis := range slots {
-			c = append(c, lis...)
-			i++
-			if i > len(slots) {
-				break
-			}
-		}
-		return nil
-	})
+	ms, done := r.acquire()
+	defer done()
+
+	matches, keys := getMatches(search, ms.getIndex)
+	results := make([]Config, len(matches))
 
-	for i, hdlr := range r.matchers.getIndex {
+	wg, ctx := errgroup.WithContext(ctx)
+	for i, hdlr := range ms.getIndex {
 		i, hdlr := i, hdlr
+		if len(matches[i].NamespaceSearch) == 0 {
+			continue
+		}
 
 		wg.Go(func() error {
 			span.AddEvent(fmt.Sprintf("INDEX %s %s", hdlr.Name, hdlr.Match))
 			lis, err := hdlr.Handler.GetIndex(ctx, matches[i])
-			slots <- lis
+			results[i] = pageResult(matches[i], hdlr.Handler, lis)
 			return err
 		})
 	}
 
-	err = wg.Wait()
+	err := wg.Wait()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return
+	var c Config
+	for _, lis := range results {
+		c = append(c, lis...)
+	}
+
+	c, next := nextPage(search, keys, results, c)
+	return c, next, nil
 }
 
 // Search query each handler with a key=value search
 
 // GetConfig query each handler that match for fully qualified namespaces.
 func (r *registry) GetConfig(ctx context.Context, search Search) (Config, error) {
+	c, _, err := r.GetConfigPage(ctx, search)
+	return c, err
+}
+
+// GetConfigPage query each handler that match for fully qualified namespaces.
+// If the search has a count the cursor to the next page is returned.
+func (r *registry) GetConfigPage(ctx context.Context, search Search) (Config, string, error) {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
-	matches := getMatches(search, r.matchers)
+	if search.Err != nil {
+		span.RecordError(search.Err)
+		return nil, "", search.Err
+	}
+
+	ms, done := r.acquire()
+	defer done()
+
+	matches, keys := getMatches(search, ms.getConfig)
+	results := make([]Config, len(matches))
 
 	m := make(SpaceMap)
-	for i, hdlr := range r.matchers.getConfig {
+	for i, hdlr := range ms.getConfig {
 		if len(matches[i].NamespaceSearch) == 0 {
 			continue
 		}
 		span.AddEvent(fmt.Sprintf("QUERY %s %s", hdlr.Name, hdlr.Match))
 		lis, err := hdlr.Handler.GetConfig(ctx, matches[i])
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		results[i] = pageResult(matches[i], hdlr.Handler, r.open(lis))
+		m.MergeWith(hdlr.Merge, results[i]...)
+	}
+
+	c, next := nextPage(search, keys, results, m.ToArray())
+
+	var err error
+	if search.Inherit {
+		if c, err = r.inherit(ctx, c); err != nil {
+			return nil, "", err
+		}
+	}
+	if search.Resolve {
+		if c, err = r.resolve(ctx, c); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// project values for handlers that do not support fields.
+	if len(search.Fields) > 0 {
+		for i, s := range c {
+			c[i] = s.Project(search.Fields...)
 		}
-		m.Merge(lis...)
 	}
 
-	return m.ToArray(), nil
+	return c, next, nil
 }
 
 // WriteConfig write objects to backends
@@ -308,10 +530,13 @@ func (r *registry) WriteConfig(ctx context.Context, spaces Config) error {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
-	matches := make([]Config, len(r.matchers.writeConfig))
+	ms, done := r.acquire()
+	defer done()
+
+	matches := make([]Config, len(ms.writeConfig))
 
 	for _, s := range spaces {
-		for i, hdlr := range r.matchers.writeConfig {
+		for i, hdlr := range ms.writeConfig {
 			if hdlr.Match.Match(s.Space) {
 				matches[i] = append(matches[i], s)
 				break
@@ -319,17 +544,85 @@ func (r *registry) WriteConfig(ctx context.Context, spaces Config) error {
 		}
 	}
 
-	for i, hdlr := range r.matchers.writeConfig {
+	for i, hdlr := range ms.writeConfig {
 		if len(matches[i]) == 0 {
 			continue
 		}
 		span.AddEvent(fmt.Sprint("WRITE MATCH", hdlr.Name, hdlr.Match))
-		err := hdlr.Handler.WriteConfig(ctx, matches[i])
+		sealed, err := r.seal(matches[i])
+		if err != nil {
+			return err
+		}
+		err = hdlr.Handler.WriteConfig(ctx, sealed)
 		if err != nil {
 			return err
 		}
+		r.publish(matches[i])
+	}
+
+	return r.reloadIfChanged(ctx, spaces)
+}
+
+// ErrPreconditionFailed is returned when a conditional write finds the stored config has changed.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// WriteConfigIf write objects to a backend if check passes for the stored version.
+// A conditional write must be handled by a single backend.
+func (r *registry) WriteConfigIf(ctx context.Context, spaces Config, check func(current Config) error) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	ms, done := r.acquire()
+	defer done()
+
+	var hdlr *matcher[WriteConfig]
+	var match Config
+	for _, s := range spaces {
+		for i := range ms.writeConfig {
+			if ms.writeConfig[i].Match.Match(s.Space) {
+				if hdlr != nil && hdlr != &ms.writeConfig[i] {
+					return fmt.Errorf("conditional write spans more than one source")
+				}
+				hdlr = &ms.writeConfig[i]
+				match = append(match, s)
+				break
+			}
+		}
+	}
+	if hdlr == nil {
+		return nil
+	}
+
+	w, ok := hdlr.Handler.(WriteConfigIf)
+	if !ok {
+		return fmt.Errorf("source does not support conditional writes: %s", hdlr.Name)
+	}
+
+	span.AddEvent(fmt.Sprint("WRITE IF MATCH", hdlr.Name, hdlr.Match))
+	sealed, err := r.seal(match)
+	if err != nil {
+		return err
+	}
+	err = w.WriteConfigIf(ctx, sealed, func(current Config) error { return check(r.open(current)) })
+	if err != nil {
+		return err
 	}
+	r.publish(match)
 
+	return r.reloadIfChanged(ctx, match)
+}
+
+// reloadIfChanged reloads the registry if any source or output spaces were written.
+func (r *registry) reloadIfChanged(ctx context.Context, spaces Config) error {
+	search := ParseSearch(sourceSearch)
+	for _, s := range spaces {
+		if search.NamespaceSearch.Match(s.Space) {
+			if err := r.Reload(ctx); err != nil {
+				return fmt.Errorf("config written but reload failed: %w", err)
+			}
+			return nil
+		}
+	}
 	return nil
 }
 
@@ -338,8 +631,11 @@ func (r *registry) GetRules(ctx context.Context, user ident.Ident) (Rules, error
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
+	ms, done := r.acquire()
+	defer done()
+
 	s := set.New[Rule]()
-	for _, hdlr := range r.matchers.getRules {
+	for _, hdlr := range ms.getRules {
 		span.AddEvent(fmt.Sprint("RULES", hdlr.Name, hdlr.Match))
 		lis, err := hdlr.Handler.GetRules(ctx, user)
 		if err != nil {
@@ -352,13 +648,32 @@ func (r *registry) GetRules(ctx context.Context, user ident.Ident) (Rules, error
 	return rules, nil
 }
 
-// GetNotify query each of the handlers for rules.
+// notifySpace lists the notify rules as read by ParseNotify.
+const notifySpace = "mercury.notify"
+
+// GetNotify returns the rules for event. They are read from the notify space
+// of the sources, so secret values are opened, and from each of the handlers
+// that keep rules elsewhere.
 func (r *registry) GetNotify(ctx context.Context, event string) (ListNotify, error) {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
+	config, err := r.GetConfig(ctx, ParseSearch(notifySpace))
+	if err != nil {
+		return nil, err
+	}
+
 	s := set.New[Notify]()
-	for _, hdlr := range r.matchers.getNotify {
+	for _, c := range config {
+		if c.Space == notifySpace {
+			s.Add(ParseNotify(c, event)...)
+		}
+	}
+
+	ms, done := r.acquire()
+	defer done()
+
+	for _, hdlr := range ms.getNotify {
 		span.AddEvent(fmt.Sprint("GET NOTIFY", hdlr.Name, hdlr.Match))
 
 		lis, err := hdlr.Handler.GetNotify(ctx, event)
@@ -371,20 +686,76 @@ func (r *registry) GetNotify(ctx context.Context, event string) (ListNotify, err
 	return s.Values(), nil
 }
 
-func (r *registry) SendNotify(ctx context.Context, n Notify) (err error) {
+// NotifyTimeout bounds the delivery of a notify by each output.
+var NotifyTimeout = 30 * time.Second
+
+// SendNotify delivers the notify to each output whose match fits its method
+// or URL scheme. Outputs are sent to concurrently, each within NotifyTimeout,
+// and the error names every output that failed.
+func (r *registry) SendNotify(ctx context.Context, n Notify) error {
+	_, err := r.sendNotify(ctx, n, nil)
+	return err
+}
+
+// sendNotify delivers the notify to the outputs not named in skip. It
+// returns the spaces of the outputs that accepted it.
+func (r *registry) sendNotify(ctx context.Context, n Notify, skip []string) ([]string, error) {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
-	for _, hdlr := range r.matchers.sendNotify {
+	ms, done := r.acquire()
+	defer done()
+
+	var scheme string
+	if u, err := url.Parse(n.URL); err == nil {
+		scheme = u.Scheme
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ms.sendNotify))
+	delivered := make([]bool, len(ms.sendNotify))
+	seen := make(map[string]struct{}, len(ms.sendNotify))
+	for i, hdlr := range ms.sendNotify {
+		if !hdlr.Match.Match(n.Method) && (scheme == "" || !hdlr.Match.Match(scheme)) {
+			continue
+		}
+		if slices.Contains(skip, hdlr.Space) {
+			continue
+		}
+		// an output with several matches is sent to once.
+		if _, ok := seen[hdlr.Space]; ok {
+			continue
+		}
+		seen[hdlr.Space] = struct{}{}
+
 		span.AddEvent(fmt.Sprint("SEND NOTIFY", hdlr.Name, hdlr.Match))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-		err := hdlr.Handler.SendNotify(ctx, n)
-		if err != nil {
-			return err
+			ctx, cancel := context.WithTimeout(ctx, NotifyTimeout)
+			defer cancel()
+
+			if err := hdlr.Handler.SendNotify(ctx, n); err != nil {
+				errs[i] = fmt.Errorf("notify %s: %w", hdlr.Name, err)
+				return
+			}
+			delivered[i] = true
+		}()
+	}
+	wg.Wait()
+
+	var sent []string
+	for i, hdlr := range ms.sendNotify {
+		if delivered[i] {
+			sent = append(sent, hdlr.Space)
 		}
 	}
 
-	return
+	err := errors.Join(errs...)
+	span.RecordError(err)
+
+	return sent, err
 }
 
 // Check if name matches notify
@@ -403,6 +774,70 @@ type Notify struct {
 	Event  string
 	Method string
 	URL    string
+
+	// Header holds extra request headers as "Name: value" lines.
+	Header string `json:",omitempty"`
+	// Template is a text/template for the body executed with the payload.
+	Template string `json:",omitempty"`
+
+	// Payload is set when the notify is sent for a change.
+	Payload *NotifyPayload `json:",omitempty"`
+}
+
+// NotifyPayload describes the change a notify is sent for. Hash is the
+// content hash of the changed spaces, as in the ETag of a read of them.
+type NotifyPayload struct {
+	Event    string    `json:"event"`
+	Name     string    `json:"name"`
+	Spaces   []string  `json:"spaces"`
+	Identity string    `json:"identity"`
+	Hash     string    `json:"hash"`
+	Time     time.Time `json:"time"`
+}
+
+// ParseNotify reads the notifies for event from a mercury.notify space. Each
+// key is a notify name with values that are a rule or an option for the
+// rules of the key.
+//
+//	app :app.* updated POST https://example.com/hook
+//	    :header X-Token: abc
+//	    :template {"text": "{{.Identity}} updated {{join .Spaces ", "}}"}
+func ParseNotify(s *Space, event string) (lis ListNotify) {
+	if s == nil {
+		return nil
+	}
+
+	for _, v := range s.List {
+		var header []string
+		var template string
+		var rules ListNotify
+		for _, rule := range v.Values {
+			opt, value, _ := strings.Cut(rule, " ")
+			switch opt {
+			case "header":
+				header = append(header, value)
+				continue
+			case "template":
+				template = value
+				continue
+			}
+
+			n := Notify{Name: v.Name}
+			n.Match, rule, _ = strings.Cut(rule, " ")
+			n.Event, rule, _ = strings.Cut(rule, " ")
+			n.Method, n.URL, _ = strings.Cut(rule, " ")
+			if n.Event == event {
+				rules = append(rules, n)
+			}
+		}
+		for _, n := range rules {
+			n.Header = strings.Join(header, "\n")
+			n.Template = template
+			lis = append(lis, n)
+		}
+	}
+
+	return lis
 }
 
 // ListNotify array of notify
@@ -0,0 +1,235 @@
+package mercury_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+)
+
+type closer struct {
+	name   string
+	closed chan struct{}
+}
+
+func (c *closer) GetIndex(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	return mercury.Config{mercury.NewSpace(c.name)}, nil
+}
+func (c *closer) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestConfigureSwap(t *testing.T) {
+	is := is.New(t)
+
+	handlers := make(map[string]*closer)
+	mercury.Registry.Register("test-closer", func(s *mercury.Space) any {
+		c := &closer{name: s.Space, closed: make(chan struct{})}
+		handlers[s.Space] = c
+		return c
+	})
+	mercury.Registry.Register("test-fail", func(s *mercury.Space) any {
+		return fmt.Errorf("bad config")
+	})
+
+	source := func(names ...string) mercury.SpaceMap {
+		m := make(mercury.SpaceMap)
+		for _, name := range names {
+			s := mercury.NewSpace(name)
+			s.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+			m[name] = s
+		}
+		return m
+	}
+	index := func() string {
+		lis, err := mercury.Registry.GetIndex(context.Background(), mercury.ParseSearch("*"))
+		is.NoErr(err)
+		is.Equal(len(lis), 1)
+		return lis[0].Space
+	}
+
+	is.NoErr(mercury.Registry.Configure(source("mercury.source.test-closer.a")))
+	is.Equal(index(), "mercury.source.test-closer.a")
+
+	// a failed handler keeps the current set and closes the new handlers.
+	err := mercury.Registry.Configure(source("mercury.source.test-closer.b", "mercury.source.test-fail.c"))
+	is.True(err != nil)
+	is.Equal(index(), "mercury.source.test-closer.a")
+
+	is.NoErr(mercury.Registry.Configure(source("mercury.source.test-closer.b")))
+	is.Equal(index(), "mercury.source.test-closer.b")
+
+	select {
+	case <-handlers["mercury.source.test-closer.a"].closed:
+	case <-time.After(time.Second):
+		t.Fatal("replaced handler was not closed")
+	}
+}
+
+type static struct{ text string }
+
+func (s static) GetConfig(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	m, err := mercury.ParseText(strings.NewReader(s.text))
+	if err != nil {
+		return nil, err
+	}
+	return m.ToArray(), nil
+}
+
+func TestConfigureMerge(t *testing.T) {
+	is := is.New(t)
+
+	mercury.Registry.Register("test-static", func(s *mercury.Space) any {
+		return static{s.FirstValue("text").Join()}
+	})
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	configure := func(tags ...string) error {
+		high := mercury.NewSpace("mercury.source.test-static.high").AddKeys(
+			mercury.NewValue("match").SetValues("1 *"),
+			mercury.NewValue("text").SetValues("@app", "host :db.prod"),
+		)
+		low := mercury.NewSpace("mercury.source.test-static.low").SetTags(tags...).AddKeys(
+			mercury.NewValue("match").SetValues("2 *"),
+			mercury.NewValue("text").SetValues("@app", "host :db.local", "port :5432"),
+		)
+		return mercury.Registry.Configure(mercury.SpaceMap{high.Space: high, low.Space: low})
+	}
+	env := func() string {
+		lis, err := mercury.Registry.GetConfig(context.Background(), mercury.ParseSearch("app"))
+		is.NoErr(err)
+		return lis.EnvString()
+	}
+
+	is.NoErr(configure())
+	is.Equal(env(), "app:host=db.prod\n")
+
+	is.NoErr(configure("merge/values"))
+	is.Equal(env(), "app:host+=db.prod\napp:host+=db.local\napp:port=5432\n")
+
+	is.NoErr(configure("merge/override-by-key"))
+	is.Equal(env(), "app:host=db.prod\napp:port=5432\n")
+
+	// an unknown or missing policy fails the configure.
+	err := configure("merge/bogus")
+	is.True(err != nil)
+	is.Equal(err.Error(), `mercury.source.test-static.low: unknown merge policy: "bogus"`)
+	is.True(configure("merge") != nil)
+	is.True(configure("merge/") != nil)
+	is.Equal(env(), "app:host=db.prod\napp:port=5432\n")
+}
+
+type notifier struct {
+	name string
+	sent chan string
+	err  error
+	wait bool
+}
+
+func (n *notifier) SendNotify(ctx context.Context, _ mercury.Notify) error {
+	if n.wait {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	n.sent <- n.name
+	return n.err
+}
+
+func TestSendNotifyRoutes(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	defer func(d time.Duration) { mercury.NotifyTimeout = d }(mercury.NotifyTimeout)
+	mercury.NotifyTimeout = 50 * time.Millisecond
+
+	sent := make(chan string, 10)
+	mercury.Registry.Register("test-notify", func(s *mercury.Space) any {
+		n := &notifier{name: s.Space, sent: sent}
+		switch s.Space {
+		case "mercury.output.test-notify.fail":
+			n.err = fmt.Errorf("refused")
+		case "mercury.output.test-notify.slow":
+			n.wait = true
+		}
+		return n
+	})
+	m := make(mercury.SpaceMap)
+	for name, match := range map[string][]string{
+		"web":  {"1 http|https"},
+		"mqtt": {"1 mqtt", "2 PUBLISH"},
+		"fail": {"1 POST"},
+		"slow": {"1 mqtts"},
+	} {
+		s := mercury.NewSpace("mercury.output.test-notify." + name)
+		s.AddKeys(mercury.NewValue("match").SetValues(match...))
+		m[s.Space] = s
+	}
+	is.NoErr(mercury.Registry.Configure(m))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	received := func() []string {
+		var lis []string
+		for {
+			select {
+			case name := <-sent:
+				lis = append(lis, name)
+			default:
+				slices.Sort(lis)
+				return lis
+			}
+		}
+	}
+
+	// an output matched by both method and scheme is sent to once.
+	is.NoErr(mercury.Registry.SendNotify(ctx, mercury.Notify{Method: "PUBLISH", URL: "mqtt://broker/app"}))
+	is.Equal(received(), []string{"mercury.output.test-notify.mqtt"})
+
+	is.NoErr(mercury.Registry.SendNotify(ctx, mercury.Notify{Method: "PUT", URL: "https://example.com/hook"}))
+	is.Equal(received(), []string{"mercury.output.test-notify.web"})
+
+	// each failed output is named in the error.
+	err := mercury.Registry.SendNotify(ctx, mercury.Notify{Method: "POST", URL: "http://example.com/hook"})
+	is.Equal(received(), []string{"mercury.output.test-notify.fail", "mercury.output.test-notify.web"})
+	is.True(err != nil)
+	is.Equal(err.Error(), "notify fail: refused")
+
+	err = mercury.Registry.SendNotify(ctx, mercury.Notify{Method: "POST", URL: "mqtts://broker/app"})
+	is.Equal(received(), []string{"mercury.output.test-notify.fail"})
+	is.True(errors.Is(err, context.DeadlineExceeded))
+	is.True(strings.Contains(err.Error(), "notify fail: refused"))
+	is.True(strings.Contains(err.Error(), "notify slow: context deadline exceeded"))
+
+	is.NoErr(mercury.Registry.SendNotify(ctx, mercury.Notify{Method: "GET", URL: "ftp://example.com"}))
+	is.Equal(len(received()), 0)
+}
+
+// notifyFunc is not comparable so it can not be used as a map key.
+type notifyFunc func(context.Context, mercury.Notify) error
+
+func (fn notifyFunc) SendNotify(ctx context.Context, n mercury.Notify) error { return fn(ctx, n) }
+
+func TestSendNotifyFunc(t *testing.T) {
+	is := is.New(t)
+
+	var calls int
+	mercury.Registry.Register("test-func", func(s *mercury.Space) any {
+		return notifyFunc(func(context.Context, mercury.Notify) error {
+			calls++
+			return nil
+		})
+	})
+	out := mercury.NewSpace("mercury.output.test-func.default")
+	out.AddKeys(mercury.NewValue("match").SetValues("1 http", "2 POST"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{out.Space: out}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	is.NoErr(mercury.Registry.SendNotify(context.Background(), mercury.Notify{Method: "POST", URL: "http://example.com/hook"}))
+	is.Equal(calls, 1)
+}
@@ -0,0 +1,160 @@
+package mercury
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.sour.is/pkg/ident"
+)
+
+// ErrReference is returned for a reference that can not be resolved.
+var ErrReference = errors.New("reference")
+
+// resolver expands ${space:key} and ${env:NAME} references in values. Spaces
+// are read through the registry and must be readable by the user. Variables
+// must be granted with a read rule of type ENV. A key with more than one
+// value resolves to its first value and $${ is a literal ${.
+type resolver struct {
+	ctx    context.Context
+	r      *registry
+	rules  Rules
+	spaces SpaceMap
+	stack  []string
+}
+
+func (r *registry) resolve(ctx context.Context, lis Config) (Config, error) {
+	rules, err := r.GetRules(ctx, ident.FromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &resolver{ctx: ctx, r: r, rules: rules, spaces: lis.ToSpaceMap()}
+
+	out := make(Config, len(lis))
+	for i, s := range lis {
+		out[i] = s
+		if !hasReferences(s) {
+			continue
+		}
+
+		s = s.Clone()
+		for j, v := range s.List {
+			for k, value := range v.Values {
+				if s.List[j].Values[k], err = rv.expand(s.Space, v.Name, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		out[i] = s
+	}
+
+	return out, nil
+}
+
+func hasReferences(s *Space) bool {
+	for _, v := range s.List {
+		for _, value := range v.Values {
+			if strings.Contains(value, "${") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expand replaces the references in the value of space:name.
+func (rv *resolver) expand(space, name, value string) (string, error) {
+	ref := space + ":" + name
+	for _, s := range rv.stack {
+		if s == ref {
+			return "", fmt.Errorf("%w cycle: %s -> %s", ErrReference, strings.Join(rv.stack, " -> "), ref)
+		}
+	}
+	rv.stack = append(rv.stack, ref)
+	defer func() { rv.stack = rv.stack[:len(rv.stack)-1] }()
+
+	var buf strings.Builder
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && value[i-1] == '$' {
+			buf.WriteString(value[:i])
+			buf.WriteString("{")
+			value = value[i+2:]
+			continue
+		}
+
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("%w: unterminated in %s", ErrReference, ref)
+		}
+
+		v, err := rv.lookup(value[i+2 : i+end])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(value[:i])
+		buf.WriteString(v)
+		value = value[i+end+1:]
+	}
+	buf.WriteString(value)
+
+	return buf.String(), nil
+}
+
+// lookup returns the resolved value of a space:key or env:NAME reference.
+func (rv *resolver) lookup(ref string) (string, error) {
+	space, name, ok := strings.Cut(ref, ":")
+	if !ok || space == "" || name == "" {
+		return "", fmt.Errorf("%w: %q is not space:key or env:NAME", ErrReference, ref)
+	}
+
+	if space == "env" {
+		if !rv.rules.GetRoles("ENV", name).HasRole("read") {
+			return "", fmt.Errorf("%w: %s: access denied", ErrReference, ref)
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%w: %s: not set", ErrReference, ref)
+		}
+		return v, nil
+	}
+
+	if !canRead(rv.rules, space) {
+		return "", fmt.Errorf("%w: %s: access denied", ErrReference, ref)
+	}
+
+	s, ok := rv.spaces[space]
+	if !ok {
+		lis, err := rv.r.GetConfig(rv.ctx, ParseSearch(space))
+		if err != nil {
+			return "", err
+		}
+		if len(lis) == 0 {
+			return "", fmt.Errorf("%w: %s: no such space", ErrReference, ref)
+		}
+		s = lis[0]
+		rv.spaces[space] = s
+	}
+
+	v := s.FirstValue(name)
+	if v.Name == "" {
+		return "", fmt.Errorf("%w: %s: no such key", ErrReference, ref)
+	}
+	if v.HasTag(SecretTag) && !rv.rules.GetRoles("NS", space).HasRole("reveal") {
+		return Redacted, nil
+	}
+
+	return rv.expand(space, name, v.First())
+}
+
+// canRead returns true if the rules grant read access to the space.
+func canRead(rules Rules, space string) bool {
+	roles := rules.GetRoles("NS", space)
+	return roles.HasRole("read", "write") && !roles.HasRole("deny")
+}
@@ -0,0 +1,88 @@
+package mercury_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+)
+
+func TestResolve(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	t.Setenv("TEST_RESOLVE_REGION", "eu")
+	t.Setenv("TEST_RESOLVE_HIDDEN", "hidden")
+
+	seed, err := mercury.ParseText(strings.NewReader(`
+@mercury.groups
+readers :anon
+
+@mercury.policy
+readers :read NS app.*
+        :read NS db
+        :read ENV TEST_RESOLVE_REGION
+
+@db
+host          :db.example.com
+port          :5432
+password secret :hunter2
+url           :postgres://${db:host}:${db:port}
+
+@private
+token :abc
+
+@app.one
+dsn    :${db:url}/one?password=${db:password}
+region :${env:TEST_RESOLVE_REGION}
+raw    :$${db:host}
+
+@app.loop
+a :${app.loop:b}
+b :${app.loop:a}
+
+@app.private
+token :${private:token}
+
+@app.env
+hidden :${env:TEST_RESOLVE_HIDDEN}
+`))
+	is.NoErr(err)
+
+	mercury.Registry.Register("test-resolve", func(s *mercury.Space) any { return mem.New(seed) })
+	src := mercury.NewSpace("mercury.source.test-resolve.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	// the raw form is returned without resolve.
+	lis, err := mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.one"))
+	is.NoErr(err)
+	is.Equal(lis[0].FirstValue("dsn").First(), "${db:url}/one?password=${db:password}")
+
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app.one resolve"))
+	is.NoErr(err)
+	is.Equal(lis.EnvString(), strings.Join([]string{
+		"app.one:dsn=postgres://db.example.com:5432/one?password=***",
+		"app.one:region=eu",
+		"app.one:raw=${db:host}",
+		"",
+	}, "\n"))
+
+	for _, tt := range []struct {
+		space string
+		want  string
+	}{
+		{"app.loop", "reference cycle: app.loop:a -> app.loop:b -> app.loop:a"},
+		{"app.private", "reference: private:token: access denied"},
+		{"app.env", "reference: env:TEST_RESOLVE_HIDDEN: access denied"},
+	} {
+		_, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch(tt.space+" resolve"))
+		is.True(errors.Is(err, mercury.ErrReference))
+		is.Equal(err.Error(), tt.want)
+	}
+}
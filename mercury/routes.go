@@ -1,13 +1,17 @@
 package mercury
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -36,6 +40,11 @@ func (s *root) RegisterAPIv1(mux *http.ServeMux) {
 	// mux.HandleFunc("/mercury/config", s.configV1)
 	mux.HandleFunc("GET /mercury/config", s.configV1)
 	mux.HandleFunc("POST /mercury/config", s.storeV1)
+	mux.HandleFunc("GET /mercury/diff", s.diffV1)
+	mux.HandleFunc("POST /mercury/rollback", s.rollbackV1)
+	mux.HandleFunc("GET /mercury/watch", s.watchV1)
+	mux.HandleFunc("GET /mercury/audit", s.auditV1)
+	mux.HandleFunc("GET /mercury/outbox", s.outboxV1)
 }
 func (s *root) RegisterWellKnown(mux *http.ServeMux) {
 	s.RegisterAPIv1(mux)
@@ -71,16 +80,25 @@ func (s *root) configV1(w http.ResponseWriter, r *http.Request) {
 
 	log.Print("SPC:  ", space)
 	ns := ParseSearch(space)
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		ns.Fields = strings.Split(fields, ",")
+	}
 	log.Print("PRE:  ", ns)
 	//ns = rules.ReduceSearch(ns)
 	log.Print("POST: ", ns)
 
-	lis, err := Registry.GetConfig(ctx, ns)
+	lis, next, err := Registry.GetConfigPage(ctx, ns)
+	if errors.Is(err, ErrReference) || errors.Is(err, ErrSearch) {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	all := lis
 	lis, err = Registry.accessFilter(rules, lis)
 	if err != nil {
 		span.RecordError(err)
@@ -89,6 +107,26 @@ func (s *root) configV1(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sort.Sort(lis)
+	action := AuditRead
+	if revealed(rules, lis) {
+		action = AuditReveal
+	}
+	audit(ctx, action, AuditOK, lis)
+	auditDenied(ctx, AuditRead, all, lis)
+
+	stored, err := storedSpaces(ctx, rules, ns, lis)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, s := range stored {
+		w.Header().Add("Mercury-Version", s.Space+" "+strconv.Quote(spaceVersion(s)))
+	}
+	w.Header().Set("ETag", strconv.Quote(stored.Hash()))
+	if next != "" {
+		w.Header().Set("Mercury-Cursor", next)
+	}
 	var content string
 
 	switch httputil.NegotiateContentType(r, []string{
@@ -98,16 +136,26 @@ func (s *root) configV1(w http.ResponseWriter, r *http.Request) {
 		"application/ini",
 		"application/json",
 		"application/toml",
+		"application/yaml",
+		"application/dotenv",
 	}, "text/plain") {
 	case "text/plain":
-		content = lis.String()
+		content = lis.String() + cursorNote(next)
 	case "text/html":
-		content = lis.HTMLString()
+		content = lis.HTMLString() + cursorNote(next)
 	case "application/environ":
 		content = lis.EnvString()
 	case "application/ini":
 		content = lis.INIString()
+	case "application/yaml":
+		content = lis.YAMLString()
+	case "application/dotenv":
+		content = lis.DotEnvString()
 	case "application/json":
+		if ns.Count > 0 {
+			json.NewEncoder(w).Encode(page{lis, next})
+			break
+		}
 		json.NewEncoder(w).Encode(lis)
 	case "application/toml":
 		w.WriteHeader(200)
@@ -148,79 +196,745 @@ func (s *root) storeV1(w http.ResponseWriter, r *http.Request) {
 	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
 	switch contentType {
 	case "text/plain":
-		config, err = ParseText(r.Body)
+		config, err = ParseTextStrict(r.Body)
+		r.Body.Close()
+	case "application/json":
+		config, err = ParseJSON(r.Body)
+		r.Body.Close()
+	case "application/toml":
+		config, err = ParseTOML(r.Body)
+		r.Body.Close()
+	case "application/ini":
+		config, err = ParseINI(r.Body)
+		r.Body.Close()
+	case "application/environ":
+		config, err = ParseEnviron(r.Body)
 		r.Body.Close()
 	case "application/x-www-form-urlencoded":
 		r.ParseForm()
-		config, err = ParseText(strings.NewReader(r.Form.Get("content")))
+		config, err = ParseTextStrict(strings.NewReader(r.Form.Get("content")))
 	case "multipart/form-data":
 		r.ParseMultipartForm(1 << 20)
-		config, err = ParseText(strings.NewReader(r.Form.Get("content")))
+		config, err = ParseTextStrict(strings.NewReader(r.Form.Get("content")))
 	default:
 		http.Error(w, "PARSE_ERR", http.StatusUnsupportedMediaType)
 		return
 	}
 	if err != nil {
 		span.RecordError(err)
-		http.Error(w, "PARSE_ERR", 400)
+		http.Error(w, "PARSE_ERR\n"+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	{
-		rules, err := Registry.GetRules(ctx, id)
-		if err != nil {
-			span.RecordError(err)
+	rules, err := Registry.GetRules(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// secret values written back redacted keep their stored value.
+	err = Registry.RestoreRedacted(ctx, config)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := planWrite(ctx, rules, config)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR", http.StatusInternalServerError)
+		return
+	}
+
+	// only the spaces the user may write are validated, so a schema sent
+	// without the write role does not replace the stored one.
+	err = Registry.Validate(ctx, plan.Write)
+	if err != nil {
+		span.RecordError(err)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
 			http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		http.Error(w, "INVALID_CONFIG\n"+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		notify, err := Registry.GetNotify(ctx, "updated")
+	check := ifMatch(rules, r.Header.Values("If-Match"))
+
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		if check != nil {
+			err = dryRunCheck(ctx, plan, check)
+		}
+		plan.audit(ctx, rules, AuditDryRun, err)
+		if errors.Is(err, ErrPreconditionFailed) {
+			span.RecordError(err)
+			http.Error(w, "PRECONDITION_FAILED", http.StatusPreconditionFailed)
+			return
+		}
 		if err != nil {
 			span.RecordError(err)
 			http.Error(w, "ERR", http.StatusInternalServerError)
 			return
 		}
-		_ = rules
-		var notifyActive = make(map[string]struct{})
-		var filteredConfigs Config
-		for ns, c := range config {
-			if !rules.GetRoles("NS", ns).HasRole("write") {
-				span.AddEvent(fmt.Sprint("SKIP", ns))
-				continue
+
+		switch httputil.NegotiateContentType(r, []string{"text/plain", "application/json"}, "text/plain") {
+		case "application/json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(plan)
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, plan)
+		}
+		return
+	}
+
+	err = plan.apply(ctx, check)
+	plan.audit(ctx, rules, AuditWrite, err)
+	if errors.Is(err, ErrPreconditionFailed) {
+		span.RecordError(err)
+		http.Error(w, "PRECONDITION_FAILED", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR", http.StatusInternalServerError)
+		return
+	}
+
+	if len(plan.Skip) > 0 {
+		w.Header().Set("Mercury-Skipped", strings.Join(plan.Skip, ","))
+	}
+	w.WriteHeader(202)
+	fmt.Fprint(w, "OK")
+}
+
+// spaceVersion returns the version of a space. It is the ETag of a read of
+// only that space.
+func spaceVersion(s *Space) string {
+	return Config{s}.Hash()
+}
+
+// storedSpaces returns the spaces in lis as stored and as the user may read
+// them. The Mercury-Version of each and the ETag of the read are of these so
+// they do not change with fields, resolve, inherit or at.
+func storedSpaces(ctx context.Context, rules Rules, ns Search, lis Config) (Config, error) {
+	if len(lis) == 0 {
+		return nil, nil
+	}
+
+	stored := lis
+	if len(ns.Fields) > 0 || ns.Resolve || ns.Inherit || ns.At != nil {
+		var err error
+		stored, err = Registry.GetConfig(ctx, ParseSearch(strings.Join(spaceNames(lis), "|")))
+		if err != nil {
+			return nil, err
+		}
+		stored = stored.Redact(rules)
+		sort.Sort(stored)
+	}
+
+	return stored, nil
+}
+
+// ifMatch returns a check that the stored spaces being written match the
+// If-Match headers, either together as the ETag of a read of them or each by
+// its version. Spaces that are not stored yet are not checked. It returns nil
+// if there is no header or it is *.
+func ifMatch(rules Rules, header []string) func(Config) error {
+	tags := make(map[string]struct{})
+	for _, h := range header {
+		for _, etag := range strings.Split(h, ",") {
+			etag = strings.TrimSpace(etag)
+			if etag == "*" {
+				return nil
 			}
+			tags[strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)] = struct{}{}
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
 
-			span.AddEvent(fmt.Sprint("SAVE", ns))
-			for _, n := range notify.Find(ns) {
-				notifyActive[n.Name] = struct{}{}
+	return func(current Config) error {
+		current = current.Redact(rules)
+		if _, ok := tags[current.Hash()]; ok {
+			return nil
+		}
+		for _, s := range current {
+			if _, ok := tags[spaceVersion(s)]; !ok {
+				return fmt.Errorf("%w: @%s", ErrPreconditionFailed, s.Space)
 			}
-			filteredConfigs = append(filteredConfigs, c)
 		}
+		return nil
+	}
+}
 
-		err = Registry.WriteConfig(ctx, filteredConfigs)
-		if err != nil {
-			span.RecordError(err)
-			http.Error(w, "ERR", http.StatusInternalServerError)
+// dryRunCheck runs the If-Match check against the stored version of the
+// spaces a plan would write.
+func dryRunCheck(ctx context.Context, plan *writePlan, check func(Config) error) error {
+	if len(plan.Write) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(plan.Write))
+	for i, c := range plan.Write {
+		names[i] = c.Space
+	}
+	current, err := Registry.GetConfig(ctx, ParseSearch(strings.Join(names, "|")))
+	if err != nil {
+		return err
+	}
+
+	return check(current)
+}
+
+// writePlan is the outcome of a write before it is applied: the spaces to
+// write, the spaces skipped for lack of the write role and the notifies to send.
+type writePlan struct {
+	Write  Config
+	Skip   []string
+	Notify ListNotify
+}
+
+// planWrite splits config by the write role of the user and finds the
+// notifies for the spaces that will be written.
+func planWrite(ctx context.Context, rules Rules, config SpaceMap) (*writePlan, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	notify, err := Registry.GetNotify(ctx, "updated")
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &writePlan{}
+	var notifyActive = make(map[string]struct{})
+	for _, c := range config.ToArray() {
+		if !rules.GetRoles("NS", c.Space).HasRole("write") {
+			span.AddEvent(fmt.Sprint("SKIP", c.Space))
+			plan.Skip = append(plan.Skip, c.Space)
+			continue
+		}
+
+		span.AddEvent(fmt.Sprint("SAVE", c.Space))
+		for _, n := range notify.Find(c.Space) {
+			notifyActive[n.Name] = struct{}{}
+		}
+		plan.Write = append(plan.Write, c)
+	}
+	sort.Sort(plan.Write)
+	sort.Strings(plan.Skip)
+
+	for _, n := range notify {
+		if _, ok := notifyActive[n.Name]; ok {
+			plan.Notify = append(plan.Notify, n)
+		}
+	}
+
+	return plan, nil
+}
+
+// String formats the plan as a line for each space and notify.
+func (p *writePlan) String() string {
+	var buf strings.Builder
+	for _, c := range p.Write {
+		fmt.Fprintln(&buf, "WRITE ", c.Space)
+	}
+	for _, ns := range p.Skip {
+		fmt.Fprintln(&buf, "SKIP  ", ns)
+	}
+	for _, n := range p.Notify {
+		fmt.Fprintln(&buf, "NOTIFY", n.Name, n.Method, n.URL)
+	}
+	return buf.String()
+}
+
+func (p *writePlan) MarshalJSON() ([]byte, error) {
+	var out struct {
+		Write  []string   `json:"write"`
+		Skip   []string   `json:"skip"`
+		Notify ListNotify `json:"notify"`
+	}
+	for _, c := range p.Write {
+		out.Write = append(out.Write, c.Space)
+	}
+	out.Skip, out.Notify = p.Skip, p.Notify
+
+	return json.Marshal(out)
+}
+
+// apply writes the planned spaces and queues the notifies for delivery. If
+// check is set the write is conditional on the stored version of the spaces.
+func (p *writePlan) apply(ctx context.Context, check func(Config) error) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	notify := make(ListNotify, len(p.Notify))
+	for i, n := range p.Notify {
+		n.Payload = p.payload(ctx, n)
+		notify[i] = n
+	}
+
+	span.AddEvent(fmt.Sprint("QUEUE NOTIFYS ", p.Notify))
+	err := Registry.WriteConfigNotify(ctx, p.Write, check, notify)
+	if err != nil {
+		return err
+	}
+	span.AddEvent("DONE!")
+
+	return nil
+}
+
+// payload describes the change for a notify. The hash is of the written
+// spaces it matches with secret values redacted, as returned in the ETag of
+// a read of those spaces.
+func (p *writePlan) payload(ctx context.Context, n Notify) *NotifyPayload {
+	var spaces Config
+	for _, c := range p.Write {
+		if n.Check(c.Space) {
+			spaces = append(spaces, c)
+		}
+	}
+
+	return &NotifyPayload{
+		Event:    n.Event,
+		Name:     n.Name,
+		Spaces:   spaceNames(spaces),
+		Identity: ident.FromContext(ctx).Identity(),
+		Hash:     spaces.Redact(nil).Hash(),
+		Time:     time.Now().UTC(),
+	}
+}
+
+// audit records the outcome of the plan for the written spaces and a denial
+// for the skipped spaces.
+func (p *writePlan) audit(ctx context.Context, rules Rules, action string, err error) {
+	outcome := AuditOK
+	if err != nil {
+		outcome = AuditError
+	}
+	audit(ctx, action, outcome, p.Write.Redact(rules))
+	auditSpaces(ctx, action, AuditDenied, "", p.Skip...)
+}
+
+func (s *root) diffV1(w http.ResponseWriter, r *http.Request) {
+	ctx, span := lg.Span(r.Context())
+	defer span.End()
+
+	var id = ident.FromContext(ctx)
+
+	if !id.Session().Active {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusUnauthorized)
+		return
+	}
+
+	rules, err := Registry.GetRules(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	space := query.Get("space")
+	if space == "" {
+		space = "*"
+	}
+
+	from, err := ParsePointInTime(query.Get("from"))
+	if err != nil {
+		http.Error(w, "ERR: from must be a revision or timestamp", http.StatusBadRequest)
+		return
+	}
+	var to *PointInTime
+	if query.Get("to") != "" {
+		if to, err = ParsePointInTime(query.Get("to")); err != nil {
+			http.Error(w, "ERR: to must be a revision or timestamp", http.StatusBadRequest)
 			return
 		}
+	}
 
-		span.AddEvent(fmt.Sprint("SEND NOTIFYS ", notifyActive))
-		for _, n := range notify {
-			if _, ok := notifyActive[n.Name]; ok {
-				err = Registry.SendNotify(ctx, n)
-				if err != nil {
-					span.RecordError(err)
-					http.Error(w, "ERR", http.StatusInternalServerError)
-					return
-				}
-			}
+	ns := ParseSearch(space)
+	if ns.Err != nil {
+		span.RecordError(ns.Err)
+		http.Error(w, "ERR: "+ns.Err.Error(), http.StatusBadRequest)
+		return
+	}
+	ns.At = from
+	a, err := Registry.GetConfig(ctx, ns)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ns.At = to
+	b, err := Registry.GetConfig(ctx, ns)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	allA, allB := a, b
+	if a, err = Registry.accessFilter(rules, a); err == nil {
+		b, err = Registry.accessFilter(rules, b)
+	}
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditSpaces(ctx, AuditDiff, AuditOK, "", spaceNames(slices.Concat(a, b))...)
+	auditDenied(ctx, AuditDiff, slices.Concat(allA, allB), slices.Concat(a, b))
+
+	toLabel := "current"
+	if to != nil {
+		toLabel = to.String()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "--- %s at %s\n", space, from)
+	fmt.Fprintf(w, "+++ %s at %s\n", space, toLabel)
+	fmt.Fprint(w, Diff(a, b))
+}
+
+func (s *root) rollbackV1(w http.ResponseWriter, r *http.Request) {
+	ctx, span := lg.Span(r.Context())
+	defer span.End()
+
+	var id = ident.FromContext(ctx)
+
+	if !id.Session().Active {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	space := r.Form.Get("space")
+	if space == "" {
+		http.Error(w, "ERR: missing space", http.StatusBadRequest)
+		return
+	}
+	at, err := ParsePointInTime(r.Form.Get("to"))
+	if err != nil {
+		http.Error(w, "ERR: to must be a revision or timestamp", http.StatusBadRequest)
+		return
+	}
+	ns := ParseSearch(space)
+	if ns.Err != nil {
+		span.RecordError(ns.Err)
+		http.Error(w, "ERR: "+ns.Err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rules, err := Registry.GetRules(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	current, err := Registry.GetConfig(ctx, ns)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ns.At = at
+	past, err := Registry.GetConfig(ctx, ns)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ns.At = &PointInTime{Time: time.Now()}
+	latest, err := Registry.GetConfig(ctx, ns)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// spaces the history has now but not at the revision were created after
+	// it and are written empty to remove them. Spaces without history are
+	// left alone.
+	config := past.ToSpaceMap()
+	tracked := latest.ToSpaceMap()
+	for _, c := range current {
+		if _, ok := config[c.Space]; ok {
+			continue
+		}
+		if _, ok := tracked[c.Space]; ok {
+			config[c.Space] = NewSpace(c.Space)
+		}
+	}
+
+	plan, err := planWrite(ctx, rules, config)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR", http.StatusInternalServerError)
+		return
+	}
+
+	// the restored spaces must match the schemas as they are now.
+	err = Registry.Validate(ctx, plan.Write)
+	if err != nil {
+		span.RecordError(err)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+			return
 		}
-		span.AddEvent("DONE!")
+		http.Error(w, "INVALID_CONFIG\n"+err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	err = plan.apply(ctx, nil)
+	plan.audit(ctx, rules, AuditRollback, err)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR", http.StatusInternalServerError)
+		return
+	}
+
+	if len(plan.Skip) > 0 {
+		w.Header().Set("Mercury-Skipped", strings.Join(plan.Skip, ","))
+	}
 	w.WriteHeader(202)
 	fmt.Fprint(w, "OK")
 }
 
+func (s *root) watchV1(w http.ResponseWriter, r *http.Request) {
+	ctx, span := lg.Span(r.Context())
+	defer span.End()
+
+	var id = ident.FromContext(ctx)
+
+	if !id.Session().Active {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "ERR: streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	rules, err := Registry.GetRules(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	space := r.URL.Query().Get("space")
+	if space == "" {
+		space = "*"
+	}
+
+	ns := ParseSearch(space)
+	if ns.Err != nil {
+		span.RecordError(ns.Err)
+		http.Error(w, "ERR: "+ns.Err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		ns.Fields = strings.Split(fields, ",")
+	}
+
+	updates, cancel := Registry.Watch(ns)
+	defer cancel()
+	auditSpaces(ctx, AuditWatch, AuditOK, "", space)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+
+		case c, ok := <-updates:
+			if !ok {
+				// the watch fell behind and was closed, so the client must
+				// read the spaces again.
+				fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+
+			lis, err := Registry.accessFilter(rules, Config{c})
+			if err != nil || len(lis) == 0 {
+				continue
+			}
+			c = lis[0]
+			if len(ns.Fields) > 0 {
+				c = c.Project(ns.Fields...)
+			}
+
+			b, err := json.Marshal(c)
+			if err != nil {
+				span.RecordError(err)
+				continue
+			}
+			fmt.Fprintf(w, "event: updated\ndata: %s\n\n", b)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *root) auditV1(w http.ResponseWriter, r *http.Request) {
+	ctx, span := lg.Span(r.Context())
+	defer span.End()
+
+	var id = ident.FromContext(ctx)
+
+	if !id.Session().Active {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusUnauthorized)
+		return
+	}
+
+	rules, err := Registry.GetRules(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !slices.ContainsFunc(rules, func(r Rule) bool { return r.Type == "NS" && r.Role == "audit" }) {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	search := AuditSearch{
+		Identity: query.Get("identity"),
+		Action:   query.Get("action"),
+		Limit:    100,
+	}
+	if space := query.Get("space"); space != "" {
+		search.Spaces = ParseSearch(space).NamespaceSearch
+	}
+	if v := query.Get("since"); v != "" {
+		if search.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "ERR: since must be a timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if search.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "ERR: until must be a timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if search.Limit, err = strconv.Atoi(v); err != nil || search.Limit < 1 {
+			http.Error(w, "ERR: limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	lis, err := Registry.ReadAudit(ctx, search)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// only the spaces the user has the audit role for are shown.
+	out := lis[:0]
+	for _, a := range lis {
+		a.Spaces = slices.DeleteFunc(slices.Clone(a.Spaces), func(space string) bool {
+			return !rules.GetRoles("NS", space).HasRole("audit")
+		})
+		if len(a.Spaces) > 0 {
+			out = append(out, a)
+		}
+	}
+
+	switch httputil.NegotiateContentType(r, []string{
+		"text/plain",
+		"application/json",
+	}, "text/plain") {
+	case "text/plain":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, a := range out {
+			fmt.Fprintln(w, a)
+		}
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(out)
+		span.RecordError(err)
+	}
+}
+
+// outboxV1 lists the notifies that failed delivery for users that can read
+// the notify rules.
+func (s *root) outboxV1(w http.ResponseWriter, r *http.Request) {
+	ctx, span := lg.Span(r.Context())
+	defer span.End()
+
+	var id = ident.FromContext(ctx)
+
+	if !id.Session().Active {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusUnauthorized)
+		return
+	}
+
+	rules, err := Registry.GetRules(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if role := rules.GetRoles("NS", "mercury.notify"); !role.HasRole("read", "write") || role.HasRole("deny") {
+		span.RecordError(fmt.Errorf("NO_AUTH"))
+		http.Error(w, "NO_AUTH", http.StatusForbidden)
+		return
+	}
+
+	lis, err := Registry.DeadOutbox(ctx)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch httputil.NegotiateContentType(r, []string{
+		"text/plain",
+		"application/json",
+	}, "text/plain") {
+	case "text/plain":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, item := range lis {
+			n := item.Notify
+			fmt.Fprintln(w, item.ID, item.Created.Format(time.RFC3339), n.Name, n.Method, n.URL, item.Attempts, item.Error)
+		}
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(lis)
+		span.RecordError(err)
+	}
+}
+
 func (s *root) indexV1(w http.ResponseWriter, r *http.Request) {
 	ctx, span := lg.Span(r.Context())
 	defer span.End()
@@ -254,7 +968,12 @@ func (s *root) indexV1(w http.ResponseWriter, r *http.Request) {
 	ns.NamespaceSearch = rules.ReduceSearch(ns.NamespaceSearch)
 	span.AddEvent(ns.String())
 
-	lis, err := Registry.GetIndex(ctx, ns)
+	lis, next, err := Registry.GetIndexPage(ctx, ns)
+	if errors.Is(err, ErrSearch) {
+		span.RecordError(err)
+		http.Error(w, "ERR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		span.RecordError(err)
 		http.Error(w, "ERR: "+err.Error(), http.StatusInternalServerError)
@@ -262,16 +981,37 @@ func (s *root) indexV1(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sort.Sort(lis)
+	if next != "" {
+		w.Header().Set("Mercury-Cursor", next)
+	}
 
 	switch httputil.NegotiateContentType(r, []string{
 		"text/plain",
 		"application/json",
 	}, "text/plain") {
 	case "text/plain":
-		_, err = fmt.Fprint(w, lis.StringList())
+		_, err = fmt.Fprint(w, lis.StringList()+cursorNote(next))
 		span.RecordError(err)
 	case "application/json":
-		err = json.NewEncoder(w).Encode(lis)
+		if ns.Count > 0 {
+			err = json.NewEncoder(w).Encode(page{lis, next})
+		} else {
+			err = json.NewEncoder(w).Encode(lis)
+		}
 		span.RecordError(err)
 	}
 }
+
+// page is the JSON response for a search with a count.
+type page struct {
+	List   Config `json:"list"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// cursorNote formats the next cursor as a trailing comment for text responses.
+func cursorNote(next string) string {
+	if next == "" {
+		return ""
+	}
+	return "\n# after " + next + "\n"
+}
@@ -0,0 +1,548 @@
+package mercury_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/ident"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+	"go.sour.is/pkg/service"
+)
+
+type testSession struct{ ident.Ident }
+
+func (s testSession) ReadIdent(r *http.Request) (ident.Ident, error) { return s.Ident, nil }
+func (testSession) CreateSession(context.Context, http.ResponseWriter, ident.Ident) error {
+	return nil
+}
+func (testSession) DestroySession(context.Context, http.ResponseWriter, ident.Ident) error {
+	return nil
+}
+
+// testServer serves the mercury routes for an active user backed by an
+// in-memory source seeded with text.
+func testServer(t *testing.T, text string) (http.Handler, *mem.Handler) {
+	t.Helper()
+
+	seed, err := mercury.ParseText(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mem.New(seed)
+	mercury.Registry.Register("test-routes", func(s *mercury.Space) any { return h })
+	src := mercury.NewSpace("mercury.source.test-routes.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	if err := mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mercury.Registry.Configure(mercury.SpaceMap{}) })
+
+	mux := http.NewServeMux()
+	mercury.NewHTTP().RegisterAPIv1(mux)
+
+	user := ident.NewNullUser("user", "test", "Test User", true)
+	return ident.NewHTTP(ident.NewIDM(nil, nil), testSession{user}).RegisterMiddleware(mux), h
+}
+
+const testRoutes = `
+@mercury.groups
+writers :user
+
+@mercury.policy
+writers :read NS app.*
+        :write NS app.one
+        :audit NS app.*
+
+@mercury.notify
+app :app.* updated POST http://example.com/hook
+`
+
+func TestStoreDryRun(t *testing.T) {
+	is := is.New(t)
+	srv, h := testServer(t, testRoutes)
+
+	body := "@app.one\nhost :db\n\n@app.two\nhost :db\n"
+
+	req := httptest.NewRequest("POST", "/mercury/config?dry_run=1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Body.String(), "WRITE  app.one\nSKIP   app.two\nNOTIFY app POST http://example.com/hook\n")
+
+	req = httptest.NewRequest("POST", "/mercury/config?dry_run=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Body.String(), `{"write":["app.one"],"skip":["app.two"],"notify":[{"Name":"app","Match":"app.*","Event":"updated","Method":"POST","URL":"http://example.com/hook"}]}`+"\n")
+
+	// nothing is written or sent.
+	lis, err := h.GetConfig(context.Background(), mercury.ParseSearch("app.*"))
+	is.NoErr(err)
+	is.Equal(len(lis), 0)
+	is.Equal(len(h.Sent()), 0)
+
+	// a write reports the skipped spaces.
+	req = httptest.NewRequest("POST", "/mercury/config", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	is.Equal(rec.Code, http.StatusAccepted)
+	is.Equal(rec.Header().Get("Mercury-Skipped"), "app.two")
+	is.Equal(len(h.Sent()), 0)
+	is.Equal(len(h.Outbox()), 1)
+
+	// the notify is queued with the change.
+	payload := h.Outbox()[0].Notify.Payload
+	is.True(payload != nil)
+	is.Equal(payload.Event, "updated")
+	is.Equal(payload.Spaces, []string{"app.one"})
+	is.Equal(payload.Identity, "user")
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/config?space=app.one", nil))
+	is.Equal(rec.Header().Get("ETag"), `"`+payload.Hash+`"`)
+}
+
+func TestStoreIfMatch(t *testing.T) {
+	is := is.New(t)
+	srv, _ := testServer(t, testRoutes+`
+@mercury.policy
+writers :write NS app.*
+
+@app.one
+host :db
+port :5432
+
+@app.two
+host :db
+`)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/config?space=app.*&fields=host", nil))
+	is.Equal(rec.Code, http.StatusOK)
+
+	// each space read has a version of the whole stored space.
+	versions := rec.Header().Values("Mercury-Version")
+	is.Equal(len(versions), 2)
+	space, version, _ := strings.Cut(versions[0], " ")
+	is.Equal(space, "app.one")
+
+	post := func(body, ifMatch string) int {
+		req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the page ETag covers both stored spaces so it matches a write of both
+	// and not of one.
+	etag := rec.Header().Get("ETag")
+	is.Equal(post("@app.one\nhost :db2\nport :5432\n", etag), http.StatusPreconditionFailed)
+	is.Equal(post("@app.one\nhost :db\nport :5432\n\n@app.two\nhost :db2\n", etag), http.StatusAccepted)
+	is.Equal(post("@app.one\nhost :db\nport :5432\n\n@app.two\nhost :db3\n", etag), http.StatusPreconditionFailed)
+
+	is.Equal(post("@app.one\nhost :db2\nport :5432\n", version), http.StatusAccepted)
+	is.Equal(post("@app.one\nhost :db3\nport :5432\n", version), http.StatusPreconditionFailed)
+
+	// a read of one space has its version as the ETag.
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/config?space=app.one", nil))
+	is.Equal(rec.Header().Get("Mercury-Version"), "app.one "+rec.Header().Get("ETag"))
+	is.Equal(post("@app.one\nhost :db3\nport :5432\n", rec.Header().Get("ETag")), http.StatusAccepted)
+}
+
+func TestStoreErrors(t *testing.T) {
+	is := is.New(t)
+	srv, _ := testServer(t, testRoutes)
+
+	req := httptest.NewRequest("POST", "/mercury/config?dry_run=1", strings.NewReader("host :db\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	is.Equal(rec.Code, http.StatusBadRequest)
+	is.Equal(rec.Body.String(), "PARSE_ERR\nline 1:1: value before a space\n")
+}
+
+func TestStoreSchemaWithoutWrite(t *testing.T) {
+	is := is.New(t)
+	srv, h := testServer(t, testRoutes+`
+@mercury.schema.app.*
+port :int
+`)
+
+	// a schema the user may not write does not replace the stored one.
+	body := "@mercury.schema.app.*\nport :bool\n\n@app.one\nport :yes\n"
+	req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	is.Equal(rec.Code, http.StatusBadRequest)
+	is.Equal(rec.Body.String(), "INVALID_CONFIG\nline 5: @app.one port: strconv.ParseInt: parsing \"yes\": invalid syntax\n")
+
+	lis, err := h.GetConfig(context.Background(), mercury.ParseSearch("app.one|mercury.schema.*"))
+	is.NoErr(err)
+	is.Equal(len(lis), 1)
+	is.Equal(lis[0].FirstValue("port").First(), "int")
+}
+
+func TestConfigBadSearch(t *testing.T) {
+	is := is.New(t)
+	srv, _ := testServer(t, testRoutes)
+
+	for path, want := range map[string]string{
+		"/mercury/config?space=app.*+at+2024-13-01": "invalid search: at:",
+		"/mercury?space=app.*+at+2024-13-01":        "invalid search: at:",
+		"/mercury/diff?space=app.*+at+x&from=1":     "invalid search: at:",
+		"/mercury/config?space=app.*+find+(host==x": "invalid search: find:",
+		"/mercury?space=app.*+find+host==x,":        "invalid search: find:",
+	} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		is.Equal(rec.Code, http.StatusBadRequest)
+		is.True(strings.Contains(rec.Body.String(), want))
+	}
+}
+
+func TestDiffBeforeHistory(t *testing.T) {
+	is := is.New(t)
+	srv, _ := testServer(t, testRoutes+"\n@app.one\nhost :old\n")
+
+	req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader("@app.one\nhost :new\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusAccepted)
+
+	// the seed has no known time so it is found before any write.
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/diff?space=app.*&from=2000-01-01T00:00:00Z", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	is.True(strings.Contains(rec.Body.String(), "-host :old\n+host :new\n"))
+}
+
+// noHistory is a source that can not read past versions.
+type noHistory struct {
+	space   *mercury.Space
+	written mercury.Config
+}
+
+func (h *noHistory) GetConfig(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	if search.At != nil {
+		return nil, nil
+	}
+	return search.Filter(mercury.Config{h.space}), nil
+}
+
+func (h *noHistory) WriteConfig(ctx context.Context, config mercury.Config) error {
+	h.written = append(h.written, config...)
+	return nil
+}
+
+func TestRollback(t *testing.T) {
+	is := is.New(t)
+	srv, h := testServer(t, testRoutes+`
+@mercury.policy
+writers :write NS app.*
+
+@app.one
+host :old
+`)
+
+	other := &noHistory{space: mercury.NewSpace("app.other").AddKeys(mercury.NewValue("host").SetValues("db"))}
+	mercury.Registry.Register("test-nohistory", func(*mercury.Space) any { return other })
+	src := mercury.NewSpace("mercury.source.test-routes.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("2 *"))
+	out := mercury.NewSpace("mercury.source.test-nohistory.default")
+	out.AddKeys(mercury.NewValue("match").SetValues("1 app.other"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src, out.Space: out}))
+
+	to := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(10 * time.Millisecond)
+
+	req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader("@app.one\nhost :new\n\n@app.two\nhost :db\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusAccepted)
+
+	req = httptest.NewRequest("POST", "/mercury/rollback", strings.NewReader("space=app.*|app.other&to="+to))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusAccepted)
+
+	// the seed is restored and the space created since is removed.
+	lis, err := h.GetConfig(context.Background(), mercury.ParseSearch("app.*"))
+	is.NoErr(err)
+	is.Equal(lis.StringList(), "@app.one\n")
+	is.Equal(lis[0].FirstValue("host").First(), "old")
+
+	// a space without history is left alone.
+	is.Equal(len(other.written), 0)
+}
+
+func TestRollbackInvalid(t *testing.T) {
+	is := is.New(t)
+	srv, h := testServer(t, testRoutes+`
+@mercury.policy
+writers :write NS app.*
+
+@mercury.schema.app.*
+host :url
+
+@app.one
+host :old
+`)
+
+	to := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(10 * time.Millisecond)
+
+	req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader("@app.one\nhost :https://db\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusAccepted)
+
+	// a revision that does not match the schema is not restored.
+	req = httptest.NewRequest("POST", "/mercury/rollback", strings.NewReader("space=app.*&to="+to))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusBadRequest)
+	is.True(strings.HasPrefix(rec.Body.String(), "INVALID_CONFIG\n"))
+	is.True(strings.Contains(rec.Body.String(), `@app.one host: "old" is not an absolute url`))
+
+	lis, err := h.GetConfig(context.Background(), mercury.ParseSearch("app.one"))
+	is.NoErr(err)
+	is.Equal(lis[0].FirstValue("host").First(), "https://db")
+}
+
+func TestWatchRoute(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	srv, _ := testServer(t, testRoutes)
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/mercury/watch?fields=host", nil)
+	is.NoErr(err)
+	res, err := http.DefaultClient.Do(req)
+	is.NoErr(err)
+	defer res.Body.Close()
+	is.Equal(res.StatusCode, http.StatusOK)
+	is.Equal(res.Header.Get("Content-Type"), "text/event-stream")
+
+	// the watch is subscribed once the headers are sent.
+	for _, text := range []string{"@other\nhost :db\n", "@app.one\nhost :db\nport :5432\n"} {
+		m, err := mercury.ParseText(strings.NewReader(text))
+		is.NoErr(err)
+		is.NoErr(mercury.Registry.WriteConfig(ctx, m.ToArray()))
+	}
+
+	// spaces the user can not read are not sent.
+	scanner := bufio.NewScanner(res.Body)
+	var event []string
+	for scanner.Scan() && scanner.Text() != "" {
+		event = append(event, scanner.Text())
+	}
+	is.Equal(len(event), 2)
+	is.Equal(event[0], "event: updated")
+
+	var s mercury.Space
+	is.NoErr(json.Unmarshal([]byte(strings.TrimPrefix(event[1], "data: ")), &s))
+	is.Equal(s.Space, "app.one")
+	is.Equal(mercury.Config{&s}.EnvString(), "app.one:host=db\n")
+}
+
+func TestAudit(t *testing.T) {
+	is := is.New(t)
+
+	// users without an audit rule can not read the log.
+	srv, _ := testServer(t, strings.Replace(testRoutes, "        :audit NS app.*\n", "", 1))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/audit", nil))
+	is.Equal(rec.Code, http.StatusForbidden)
+
+	srv, _ = testServer(t, testRoutes)
+
+	req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader("@app.one\nhost :db\n\n@app.two\nhost :db\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusAccepted)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/config?space=app.*", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	etag := strings.Trim(rec.Header().Get("ETag"), `"`)
+
+	req = httptest.NewRequest("GET", "/mercury/audit", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusOK)
+
+	var lis []mercury.Audit
+	is.NoErr(json.NewDecoder(rec.Body).Decode(&lis))
+	is.Equal(len(lis), 3)
+
+	is.Equal(lis[0].Action, mercury.AuditRead)
+	is.Equal(lis[0].Outcome, mercury.AuditOK)
+	is.Equal(lis[0].Identity, "user")
+	is.Equal(lis[0].Spaces, []string{"app.one"})
+	is.Equal(lis[0].Hash, etag)
+
+	is.Equal(lis[1].Action, mercury.AuditWrite)
+	is.Equal(lis[1].Outcome, mercury.AuditDenied)
+	is.Equal(lis[1].Spaces, []string{"app.two"})
+
+	is.Equal(lis[2].Action, mercury.AuditWrite)
+	is.Equal(lis[2].Outcome, mercury.AuditOK)
+	is.Equal(lis[2].Spaces, []string{"app.one"})
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/audit?action=write&space=app.two&limit=5", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(strings.Count(rec.Body.String(), "\n"), 1)
+	is.True(strings.Contains(rec.Body.String(), " user - write denied app.two -\n"))
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/mercury/audit?since=yesterday", nil))
+	is.Equal(rec.Code, http.StatusBadRequest)
+}
+
+func TestRunOutbox(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, h := testServer(t, testRoutes)
+	is.NoErr(mercury.Registry.QueueNotify(ctx, mercury.Notify{Name: "app", Event: "updated", Method: "POST", URL: "http://example.com/hook"}))
+
+	// the harness crontab delivers the outbox when it starts.
+	var svc service.Harness
+	is.NoErr(svc.Setup(ctx, mercury.Registry.RunOutbox))
+	go svc.Run(ctx, "test", "dev")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.Sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	is.Equal(len(h.Sent()), 1)
+	is.Equal(len(h.Outbox()), 0)
+}
+
+func TestOutboxLease(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	defer func(d time.Duration) { mercury.OutboxLease = d }(mercury.OutboxLease)
+	mercury.OutboxLease = mercury.NotifyTimeout
+
+	_, h := testServer(t, testRoutes)
+	is.NoErr(mercury.Registry.QueueNotify(ctx, mercury.Notify{Name: "app", Event: "updated", Method: "POST", URL: "http://example.com/hook"}))
+
+	// a lease too short to send within is held and left for a later run.
+	now := time.Now().Add(time.Second)
+	is.NoErr(mercury.Registry.DeliverOutbox(ctx, now))
+	is.Equal(len(h.Sent()), 0)
+	is.Equal(h.Outbox()[0].Next, now.Add(mercury.OutboxLease))
+}
+
+type failNotify struct{}
+
+func (failNotify) SendNotify(context.Context, mercury.Notify) error {
+	return errors.New("unreachable")
+}
+
+func TestOutbox(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	srv, h := testServer(t, testRoutes+`
+@mercury.policy
+writers :read NS mercury.notify
+`)
+
+	defer func(n int) { mercury.OutboxAttempts = n }(mercury.OutboxAttempts)
+	mercury.OutboxAttempts = 2
+
+	req := httptest.NewRequest("POST", "/mercury/config", strings.NewReader("@app.one\nhost :db\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusAccepted)
+
+	// delivery happens when the outbox is run.
+	is.Equal(len(h.Sent()), 0)
+	now := time.Now()
+	is.NoErr(mercury.Registry.DeliverOutbox(ctx, now))
+	is.Equal(len(h.Sent()), 1)
+	is.Equal(len(h.Outbox()), 0)
+
+	// a failing delivery is retried with backoff then kept as a dead letter.
+	mercury.Registry.Register("test-fail", func(*mercury.Space) any { return failNotify{} })
+	src := mercury.NewSpace("mercury.source.test-routes.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	out := mercury.NewSpace("mercury.output.test-fail.default")
+	out.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src, out.Space: out}))
+
+	is.NoErr(mercury.Registry.QueueNotify(ctx, mercury.Notify{Name: "app", Event: "updated", Method: "POST", URL: "http://example.com/hook"}))
+	now = time.Now()
+	is.NoErr(mercury.Registry.DeliverOutbox(ctx, now))
+	lis := h.Outbox()
+	is.Equal(len(lis), 1)
+	is.Equal(lis[0].Attempts, 1)
+	is.Equal(lis[0].Error, "notify default: unreachable")
+	is.Equal(lis[0].Next, now.Add(mercury.OutboxBackoff))
+	is.Equal(lis[0].Sent, []string{"mercury.source.test-routes.default"})
+	is.Equal(len(h.Sent()), 2)
+
+	// not due until the backoff has passed.
+	is.NoErr(mercury.Registry.DeliverOutbox(ctx, now.Add(time.Second)))
+	is.Equal(h.Outbox()[0].Attempts, 1)
+
+	// the retry only sends to the output that failed.
+	is.NoErr(mercury.Registry.DeliverOutbox(ctx, now.Add(mercury.OutboxBackoff)))
+	lis = h.Outbox()
+	is.Equal(lis[0].Attempts, 2)
+	is.True(lis[0].Dead)
+	is.Equal(len(h.Sent()), 2)
+
+	is.NoErr(mercury.Registry.DeliverOutbox(ctx, now.Add(24*time.Hour)))
+	is.Equal(h.Outbox()[0].Attempts, 2)
+
+	req = httptest.NewRequest("GET", "/mercury/outbox", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusOK)
+
+	var dead []mercury.OutboxItem
+	is.NoErr(json.NewDecoder(rec.Body).Decode(&dead))
+	is.Equal(len(dead), 1)
+	is.Equal(dead[0].Notify.Name, "app")
+	is.Equal(dead[0].Error, "notify default: unreachable")
+}
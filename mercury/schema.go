@@ -0,0 +1,281 @@
+package mercury
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const schemaPrefix = "mercury.schema."
+
+// Schema declares the keys of the spaces matching its pattern. It is read
+// from a space named mercury.schema.<pattern>. Keys are tagged required or
+// multiple and the value is the type followed by its arguments. A strict
+// schema rejects keys it does not declare.
+//
+//	@mercury.schema.app.* strict
+//	host     required :url
+//	port              :int
+//	timeout           :duration
+//	debug             :bool
+//	level             :enum debug info warn error
+//	name     required :regex ^[a-z]+$
+//	peers    multiple :url
+type Schema struct {
+	Match  string
+	Strict bool
+	Keys   []SchemaKey
+}
+
+// SchemaKey declares the type and multiplicity of a key.
+type SchemaKey struct {
+	Name     string
+	Type     string
+	Args     []string
+	Required bool
+	Multiple bool
+
+	re *regexp.Regexp
+}
+
+// ValidationError is a key in a space that does not match its schema. Line
+// is where the key, or the space for a missing key, was read from text.
+type ValidationError struct {
+	Line  int
+	Space string
+	Key   string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	var line string
+	if e.Line > 0 {
+		line = fmt.Sprintf("line %d: ", e.Line)
+	}
+	if e.Key == "" {
+		return fmt.Sprintf("%s@%s: %s", line, e.Space, e.Err)
+	}
+	return fmt.Sprintf("%s@%s %s: %s", line, e.Space, e.Key, e.Err)
+}
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// schemaTypes checks a value is of the named type.
+var schemaTypes = map[string]func(k SchemaKey, v string) error{
+	"string": func(k SchemaKey, v string) error { return nil },
+	"int": func(k SchemaKey, v string) error {
+		_, err := parseInt(v)
+		return err
+	},
+	"bool": func(k SchemaKey, v string) error {
+		_, err := parseBool(v)
+		return err
+	},
+	"duration": func(k SchemaKey, v string) error {
+		_, err := parseDuration(v)
+		return err
+	},
+	"url": func(k SchemaKey, v string) error {
+		_, err := parseURL(v)
+		return err
+	},
+	"enum": func(k SchemaKey, v string) error {
+		if !slices.Contains(k.Args, strings.TrimSpace(v)) {
+			return fmt.Errorf("%q is not one of %s", v, strings.Join(k.Args, ", "))
+		}
+		return nil
+	},
+	"regex": func(k SchemaKey, v string) error {
+		if !k.re.MatchString(strings.TrimSpace(v)) {
+			return fmt.Errorf("%q does not match %s", v, k.re)
+		}
+		return nil
+	},
+}
+
+// ParseSchema reads a schema from a mercury.schema.<pattern> space.
+func ParseSchema(s *Space) (*Schema, error) {
+	if !strings.HasPrefix(s.Space, schemaPrefix) {
+		return nil, fmt.Errorf("schema: %s is not a schema space", s.Space)
+	}
+
+	schema := &Schema{
+		Match:  strings.TrimPrefix(s.Space, schemaPrefix),
+		Strict: s.HasTag("strict"),
+	}
+	if _, err := filepath.Match(schema.Match, ""); err != nil {
+		return nil, &ValidationError{s.Line, s.Space, "", err}
+	}
+
+	var errs error
+	for _, v := range s.List {
+		typ, args, _ := strings.Cut(strings.TrimSpace(v.First()), " ")
+		k := SchemaKey{
+			Name:     v.Name,
+			Type:     typ,
+			Args:     strings.Fields(args),
+			Required: v.HasTag("required"),
+			Multiple: v.HasTag("multiple"),
+		}
+		if k.Type == "" {
+			k.Type = "string"
+		}
+
+		if _, ok := schemaTypes[k.Type]; !ok {
+			errs = errors.Join(errs, &ValidationError{v.Line, s.Space, v.Name, fmt.Errorf("unknown type %q", k.Type)})
+			continue
+		}
+		if k.Type == "regex" {
+			var err error
+			if k.re, err = regexp.Compile(strings.TrimSpace(args)); err != nil {
+				errs = errors.Join(errs, &ValidationError{v.Line, s.Space, v.Name, err})
+				continue
+			}
+		}
+
+		schema.Keys = append(schema.Keys, k)
+	}
+
+	return schema, errs
+}
+
+// Validate checks the space against the schema. Each violation is returned as
+// a ValidationError joined in the order of the keys.
+func (schema *Schema) Validate(s *Space) error {
+	keys := make(map[string]SchemaKey, len(schema.Keys))
+	for _, k := range schema.Keys {
+		keys[k.Name] = k
+	}
+
+	var errs error
+	seen := make(map[string]int)
+	for _, v := range s.List {
+		seen[v.Name]++
+
+		k, ok := keys[v.Name]
+		if !ok {
+			if schema.Strict {
+				errs = errors.Join(errs, &ValidationError{v.Line, s.Space, v.Name, fmt.Errorf("not in schema %s", schema.Match)})
+			}
+			continue
+		}
+
+		if !k.Multiple && (seen[v.Name] > 1 || len(v.Values) > 1) {
+			errs = errors.Join(errs, &ValidationError{v.Line, s.Space, v.Name, fmt.Errorf("expected a single value")})
+		}
+		for _, value := range v.Values {
+			if err := schemaTypes[k.Type](k, value); err != nil {
+				errs = errors.Join(errs, &ValidationError{v.Line, s.Space, v.Name, err})
+			}
+		}
+	}
+
+	for _, k := range schema.Keys {
+		if k.Required && seen[k.Name] == 0 {
+			errs = errors.Join(errs, &ValidationError{s.Line, s.Space, k.Name, fmt.Errorf("required")})
+		}
+	}
+
+	return errs
+}
+
+// Validate checks the spaces against the schemas stored in the registry and
+// the schemas being written with them. A schema in config takes the place of
+// the stored one, so config must only hold spaces the caller may write. Empty
+// spaces are deletes and are not checked.
+func (r *registry) Validate(ctx context.Context, config Config) error {
+	stored, err := r.GetConfig(ctx, ParseSearch(schemaPrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	m := stored.ToSpaceMap()
+	written := make(map[string]struct{})
+	for _, s := range config {
+		if strings.HasPrefix(s.Space, schemaPrefix) {
+			m[s.Space] = s
+			written[s.Space] = struct{}{}
+		}
+	}
+
+	var errs error
+	var schemas []*Schema
+	for _, s := range m.ToArray() {
+		if len(s.List) == 0 {
+			continue
+		}
+
+		// a broken stored schema is logged so it does not block unrelated writes.
+		schema, err := ParseSchema(s)
+		if _, ok := written[s.Space]; ok {
+			errs = errors.Join(errs, err)
+		} else if err != nil {
+			log.Println("schema:", err)
+		}
+		if schema != nil {
+			schemas = append(schemas, schema)
+		}
+	}
+
+	for _, s := range config {
+		if len(s.Tags) == 0 && len(s.Notes) == 0 && len(s.List) == 0 {
+			continue
+		}
+		for _, schema := range schemas {
+			if ok, _ := filepath.Match(schema.Match, s.Space); ok {
+				errs = errors.Join(errs, schema.Validate(s))
+			}
+		}
+	}
+
+	return errs
+}
+
+func parseInt(v string) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(v), 0, 64)
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(v))
+}
+
+func parseDuration(v string) (time.Duration, error) {
+	return time.ParseDuration(strings.TrimSpace(v))
+}
+
+func parseURL(v string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(v))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("%q is not an absolute url", v)
+	}
+	return u, nil
+}
+
+// Int returns the first value as an integer.
+func (v Value) Int() (int64, error) { return parseInt(v.First()) }
+
+// Bool returns the first value as a boolean. It accepts the forms of
+// strconv.ParseBool and yes, no, on and off.
+func (v Value) Bool() (bool, error) { return parseBool(v.First()) }
+
+// Duration returns the first value as a time.Duration.
+func (v Value) Duration() (time.Duration, error) { return parseDuration(v.First()) }
+
+// URL returns the first value as an absolute url.
+func (v Value) URL() (*url.URL, error) { return parseURL(v.First()) }
@@ -0,0 +1,145 @@
+package mercury_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+)
+
+const testSchema = `
+@mercury.schema.app.* strict
+host     required :url
+port              :int
+timeout           :duration
+debug             :bool
+level             :enum debug info warn error
+name     required :regex ^[a-z]+$
+peers    multiple :url
+`
+
+func TestSchemaValidate(t *testing.T) {
+	parse := func(t *testing.T, text string) *mercury.Space {
+		m, err := mercury.ParseText(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.ToArray()[0]
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"valid", "@app.one\nhost :https://db\nport :5432\ntimeout :5s\ndebug :on\nlevel :info\nname :one\npeers :http://a\npeers :http://b\n", ""},
+		{"required", "@app.one\nport :5432\n", "line 1: @app.one host: required\nline 1: @app.one name: required"},
+		{
+			"types",
+			"@app.one\nhost :db\nport :x\ntimeout :5\ndebug :maybe\nlevel :trace\nname :One\n",
+			strings.Join([]string{
+				`line 2: @app.one host: "db" is not an absolute url`,
+				`line 3: @app.one port: strconv.ParseInt: parsing "x": invalid syntax`,
+				`line 4: @app.one timeout: time: missing unit in duration "5"`,
+				`line 5: @app.one debug: strconv.ParseBool: parsing "maybe": invalid syntax`,
+				`line 6: @app.one level: "trace" is not one of debug, info, warn, error`,
+				`line 7: @app.one name: "One" does not match ^[a-z]+$`,
+			}, "\n"),
+		},
+		{"single", "@app.one\nhost :https://a\nhost :https://b\nname :one\n", "line 3: @app.one host: expected a single value"},
+		{"strict", "@app.one\nhost :https://a\nname :one\nother :1\n", "line 4: @app.one other: not in schema app.*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			schema, err := mercury.ParseSchema(parse(t, testSchema))
+			is.NoErr(err)
+
+			err = schema.Validate(parse(t, tt.in))
+			if tt.want == "" {
+				is.NoErr(err)
+				return
+			}
+			is.True(err != nil)
+			is.Equal(err.Error(), tt.want)
+		})
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	is := is.New(t)
+
+	m, err := mercury.ParseText(strings.NewReader("@mercury.schema.app\nport :integer\nname :regex [a-\n"))
+	is.NoErr(err)
+
+	_, err = mercury.ParseSchema(m.ToArray()[0])
+	is.True(err != nil)
+
+	var verr *mercury.ValidationError
+	is.True(errors.As(err, &verr))
+	is.Equal(verr.Key, "port")
+	is.Equal(verr.Line, 2)
+}
+
+func TestRegistryValidate(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	seed, err := mercury.ParseText(strings.NewReader(testSchema))
+	is.NoErr(err)
+
+	mercury.Registry.Register("test-schema", func(s *mercury.Space) any { return mem.New(seed) })
+	src := mercury.NewSpace("mercury.source.test-schema.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	config, err := mercury.ParseText(strings.NewReader("@app.one\nhost :https://db\nname :one\n\n@other\nanything :goes\n"))
+	is.NoErr(err)
+	is.NoErr(mercury.Registry.Validate(ctx, config.ToArray()))
+
+	// deletes are not validated.
+	is.NoErr(mercury.Registry.Validate(ctx, mercury.Config{mercury.NewSpace("app.two")}))
+
+	// a schema written with the config applies to it.
+	config, err = mercury.ParseText(strings.NewReader("@mercury.schema.other\nanything :int\n\n@other\nanything :goes\n"))
+	is.NoErr(err)
+	err = mercury.Registry.Validate(ctx, config.ToArray())
+	is.True(err != nil)
+	is.Equal(err.Error(), `line 5: @other anything: strconv.ParseInt: parsing "goes": invalid syntax`)
+}
+
+func TestValueTyped(t *testing.T) {
+	is := is.New(t)
+
+	v := mercury.Value{Values: []string{"42"}}
+	i, err := v.Int()
+	is.NoErr(err)
+	is.Equal(i, int64(42))
+
+	v = mercury.Value{Values: []string{"yes"}}
+	b, err := v.Bool()
+	is.NoErr(err)
+	is.True(b)
+
+	v = mercury.Value{Values: []string{"1m30s"}}
+	d, err := v.Duration()
+	is.NoErr(err)
+	is.Equal(d, 90*time.Second)
+
+	v = mercury.Value{Values: []string{"https://example.com/path"}}
+	u, err := v.URL()
+	is.NoErr(err)
+	is.Equal(u.Host, "example.com")
+
+	v = mercury.Value{Values: []string{"example.com"}}
+	_, err = v.URL()
+	is.True(err != nil)
+}
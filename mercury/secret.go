@@ -0,0 +1,233 @@
+package mercury
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"go.sour.is/pkg/env"
+)
+
+const (
+	// SecretTag marks a value that is encrypted at rest and redacted on output.
+	SecretTag = "secret"
+	// Redacted replaces secret values for users without the reveal role.
+	// Writing it back keeps the stored value.
+	Redacted = "***"
+
+	secretPrefix = "enc:v1:"
+)
+
+// ErrNoSecretKey is returned when writing a secret value without a key set.
+var ErrNoSecretKey = errors.New("no secret key set")
+
+// SetSecretKey sets the key used to encrypt secret values. If it is not set
+// the key is read from MERCURY_SECRET_KEY. With an empty key writes of secret
+// values fail with ErrNoSecretKey.
+func (r *registry) SetSecretKey(key string) {
+	r.secretMu.Lock()
+	defer r.secretMu.Unlock()
+
+	r.secret = newAEAD(key)
+	r.secretSet = true
+}
+
+func (r *registry) aead() cipher.AEAD {
+	r.secretMu.Lock()
+	defer r.secretMu.Unlock()
+
+	if !r.secretSet {
+		r.secret = newAEAD(env.Secret("MERCURY_SECRET_KEY", "").Secret())
+		r.secretSet = true
+	}
+	return r.secret
+}
+
+func newAEAD(key string) cipher.AEAD {
+	if key == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, _ := aes.NewCipher(sum[:])
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func hasSecrets(s *Space) bool {
+	return slices.ContainsFunc(s.List, func(v Value) bool { return v.HasTag(SecretTag) })
+}
+
+// seal returns the spaces with secret values encrypted. It fails if there is
+// no key to encrypt them with.
+func (r *registry) seal(lis Config) (Config, error) {
+	out := make(Config, len(lis))
+	for i, s := range lis {
+		out[i] = s
+		if !hasSecrets(s) {
+			continue
+		}
+
+		aead := r.aead()
+		if aead == nil {
+			return nil, fmt.Errorf("%s: %w", s.Space, ErrNoSecretKey)
+		}
+
+		s = s.Clone()
+		for j, v := range s.List {
+			if !v.HasTag(SecretTag) {
+				continue
+			}
+			for k, value := range v.Values {
+				if strings.HasPrefix(value, secretPrefix) {
+					continue
+				}
+				nonce := make([]byte, aead.NonceSize())
+				if _, err := rand.Read(nonce); err != nil {
+					return nil, err
+				}
+				sealed := aead.Seal(nonce, nonce, []byte(value), []byte(s.Space+":"+v.Name))
+				s.List[j].Values[k] = secretPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+			}
+		}
+		out[i] = s
+	}
+
+	return out, nil
+}
+
+// open returns the spaces with secret values decrypted. Values that can not
+// be decrypted are left as they are.
+func (r *registry) open(lis Config) Config {
+	out := make(Config, len(lis))
+	for i, s := range lis {
+		out[i] = s
+		if !hasSecrets(s) {
+			continue
+		}
+
+		aead := r.aead()
+		s = s.Clone()
+		for j, v := range s.List {
+			if !v.HasTag(SecretTag) {
+				continue
+			}
+			for k, value := range v.Values {
+				enc, ok := strings.CutPrefix(value, secretPrefix)
+				if !ok {
+					continue
+				}
+				plain, err := openValue(aead, enc, s.Space+":"+v.Name)
+				if err != nil {
+					log.Println("secret:", s.Space, v.Name, err)
+					continue
+				}
+				s.List[j].Values[k] = plain
+			}
+		}
+		out[i] = s
+	}
+
+	return out
+}
+
+func openValue(aead cipher.AEAD, enc, data string) (string, error) {
+	if aead == nil {
+		return "", ErrNoSecretKey
+	}
+	b, err := base64.RawStdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < aead.NonceSize() {
+		return "", fmt.Errorf("sealed value too short")
+	}
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(data))
+	return string(plain), err
+}
+
+// Redact returns the spaces with secret values replaced by Redacted in the
+// spaces the rules do not grant the reveal role for.
+func (lis Config) Redact(rules Rules) Config {
+	out := make(Config, len(lis))
+	for i, s := range lis {
+		out[i] = s
+		if !hasSecrets(s) || rules.GetRoles("NS", s.Space).HasRole("reveal") {
+			continue
+		}
+
+		s = s.Clone()
+		for j, v := range s.List {
+			if !v.HasTag(SecretTag) {
+				continue
+			}
+			for k := range v.Values {
+				s.List[j].Values[k] = Redacted
+			}
+		}
+		out[i] = s
+	}
+
+	return out
+}
+
+// RestoreRedacted replaces secret values written as Redacted with the
+// stored values of the same key so a redacted read can be written back.
+func (r *registry) RestoreRedacted(ctx context.Context, config SpaceMap) error {
+	var search []string
+	for _, s := range config {
+		for _, v := range s.List {
+			if v.HasTag(SecretTag) && slices.Contains(v.Values, Redacted) {
+				search = append(search, s.Space)
+				break
+			}
+		}
+	}
+	if len(search) == 0 {
+		return nil
+	}
+
+	current, err := r.GetConfig(ctx, ParseSearch(strings.Join(search, "|")))
+	if err != nil {
+		return err
+	}
+	stored := current.ToSpaceMap()
+
+	for _, name := range search {
+		s := config[name].Clone()
+		seen := make(map[string]int)
+		for j, v := range s.List {
+			n := seen[v.Name]
+			seen[v.Name]++
+			if !v.HasTag(SecretTag) {
+				continue
+			}
+
+			var prev []string
+			if c, ok := stored[name]; ok {
+				if lis := c.GetValues(v.Name); n < len(lis) {
+					prev = lis[n].Values
+				}
+			}
+			for k, value := range v.Values {
+				if value != Redacted {
+					continue
+				}
+				if k >= len(prev) {
+					return &ValidationError{v.Line, name, v.Name, fmt.Errorf("redacted value has no stored value")}
+				}
+				s.List[j].Values[k] = prev[k]
+			}
+		}
+		config[name] = s
+	}
+
+	return nil
+}
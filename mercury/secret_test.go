@@ -0,0 +1,110 @@
+package mercury_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+)
+
+func TestSecretValues(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := mem.New(nil)
+	mercury.Registry.Register("test-secret", func(s *mercury.Space) any { return h })
+	src := mercury.NewSpace("mercury.source.test-secret.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	defer mercury.Registry.SetSecretKey("")
+
+	// without a key secret values are not written.
+	mercury.Registry.SetSecretKey("")
+	m, err := mercury.ParseText(strings.NewReader("@app\nhost :db\npassword secret :hunter2\n"))
+	is.NoErr(err)
+	err = mercury.Registry.WriteConfig(ctx, m.ToArray())
+	is.True(errors.Is(err, mercury.ErrNoSecretKey))
+	stored, err := h.GetConfig(ctx, mercury.ParseSearch("app"))
+	is.NoErr(err)
+	is.Equal(len(stored), 0)
+
+	mercury.Registry.SetSecretKey("test key")
+	is.NoErr(mercury.Registry.WriteConfig(ctx, m.ToArray()))
+
+	// stored encrypted.
+	stored, err = h.GetConfig(ctx, mercury.ParseSearch("app"))
+	is.NoErr(err)
+	is.True(strings.HasPrefix(stored[0].FirstValue("password").First(), "enc:v1:"))
+	is.Equal(stored[0].FirstValue("host").First(), "db")
+
+	// read decrypted.
+	lis, err := mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app"))
+	is.NoErr(err)
+	is.Equal(lis[0].FirstValue("password").First(), "hunter2")
+
+	// redacted in every format without the reveal role.
+	rules := mercury.Rules{{Role: "read", Type: "NS", Match: "app"}}
+	redacted := lis.Redact(rules)
+	is.Equal(redacted.EnvString(), "app:host=db\napp:password secret=***\n")
+	is.True(!strings.Contains(redacted.String(), "hunter2"))
+	is.Equal(lis[0].FirstValue("password").First(), "hunter2")
+
+	rules = append(rules, mercury.Rule{Role: "reveal", Type: "NS", Match: "app"})
+	is.Equal(lis.Redact(rules).EnvString(), "app:host=db\napp:password secret=hunter2\n")
+
+	// writing back a redacted value keeps the stored value.
+	m, err = mercury.ParseText(strings.NewReader("@app\nhost :db2\npassword secret :***\n"))
+	is.NoErr(err)
+	is.NoErr(mercury.Registry.RestoreRedacted(ctx, m))
+	s, _ := m.Space("app")
+	is.Equal(s.FirstValue("password").First(), "hunter2")
+
+	m, err = mercury.ParseText(strings.NewReader("@other\npassword secret :***\n"))
+	is.NoErr(err)
+	is.True(mercury.Registry.RestoreRedacted(ctx, m) != nil)
+
+	// a different key can not read the value.
+	mercury.Registry.SetSecretKey("other key")
+	lis, err = mercury.Registry.GetConfig(ctx, mercury.ParseSearch("app"))
+	is.NoErr(err)
+	is.True(strings.HasPrefix(lis[0].FirstValue("password").First(), "enc:v1:"))
+}
+
+func TestSecretNotify(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := mem.New(nil)
+	mercury.Registry.Register("test-secret", func(s *mercury.Space) any { return h })
+	src := mercury.NewSpace("mercury.source.test-secret.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	mercury.Registry.SetSecretKey("test key")
+	defer mercury.Registry.SetSecretKey("")
+
+	m, err := mercury.ParseText(strings.NewReader(
+		"@mercury.notify\napp secret :app.* updated POST https://example.com/hook\n    :header X-Token: abc\n",
+	))
+	is.NoErr(err)
+	is.NoErr(mercury.Registry.WriteConfig(ctx, m.ToArray()))
+
+	// the rules are read with their secret values opened.
+	lis, err := mercury.Registry.GetNotify(ctx, "updated")
+	is.NoErr(err)
+	is.Equal(lis, mercury.ListNotify{{
+		Name:   "app",
+		Match:  "app.*",
+		Event:  "updated",
+		Method: "POST",
+		URL:    "https://example.com/hook",
+		Header: "X-Token: abc",
+	}})
+}
@@ -1,10 +1,14 @@
 package mercury
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.sour.is/pkg/rsql"
 )
 
 // Search implements a parsed namespace search
@@ -16,16 +20,56 @@ import (
 // test.*|mercury.*          => all prefixed with `test.` AND `mercury.`
 // test.* find bin=eq=bar    => all prefixed with `test.` AND has an attribute bin that equals bar
 // test.* fields foo,bin     => all prefixed with `test.` only show fields foo and bin
+// test.* at <rev|timestamp> => all prefixed with `test.` as they were at a revision or RFC3339 timestamp
+// test.* resolve            => all prefixed with `test.` with ${space:key} and ${env:NAME} references resolved
+// test.* inherit            => all prefixed with `test.` with the keys of extends/<space> parents layered in
 //   - count 20                => start a cursor with 20 results
 //   - count 20 after <cursor> => continue after cursor for 20 results
 //     cursor encodes start points for each of the matched sources
+//
+// A find is an RSQL expression as read by ParseFind. The legacy form
+// a=eq=1,b=eq=2 reads a comma as AND.
 type Search struct {
 	NamespaceSearch
-	Find   []ops
-	Fields []string
-	Count  uint64
-	Offset uint64
-	Cursor string
+	Find    []FindOp
+	Fields  []string
+	Count   uint64
+	Offset  uint64
+	Cursor  string
+	At      *PointInTime
+	Resolve bool
+	Inherit bool
+
+	// Err is set when a keyword could not be parsed. It wraps ErrSearch.
+	Err error
+}
+
+// ErrSearch is returned for a search with a keyword that can not be parsed.
+var ErrSearch = errors.New("invalid search")
+
+// PointInTime selects a past version of a space by revision or timestamp.
+type PointInTime struct {
+	Revision uint64
+	Time     time.Time
+}
+
+// ParsePointInTime parses a revision number or RFC3339 timestamp.
+func ParsePointInTime(s string) (*PointInTime, error) {
+	if rev, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return &PointInTime{Revision: rev}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, s); err == nil {
+		return &PointInTime{Time: ts}, nil
+	}
+	return nil, fmt.Errorf("%q is not a revision or RFC3339 timestamp", s)
+}
+
+// String output string value
+func (p PointInTime) String() string {
+	if p.Revision > 0 {
+		return strconv.FormatUint(p.Revision, 10)
+	}
+	return p.Time.Format(time.RFC3339)
 }
 
 type NamespaceSpec interface {
@@ -53,14 +97,19 @@ func ParseSearch(text string) (search Search) {
 	}
 	search.NamespaceSearch = lis
 
-	field, text, next := strings.Cut(text, " ")
+	field, text, _ := strings.Cut(strings.TrimSpace(text), " ")
 	text = strings.TrimSpace(text)
-	for next {
+	for field != "" {
 		switch strings.ToLower(field) {
 		case "find":
 			field, text, _ = strings.Cut(text, " ")
 			text = strings.TrimSpace(text)
-			search.Find = simpleParse(field)
+			var err error
+			if legacyFind(field) {
+				search.Find = simpleParse(field)
+			} else if search.Find, err = ParseFind(field); err != nil {
+				search.Err = errors.Join(search.Err, fmt.Errorf("%w: %w", ErrSearch, err))
+			}
 
 		case "fields":
 			field, text, _ = strings.Cut(text, " ")
@@ -81,8 +130,22 @@ func ParseSearch(text string) (search Search) {
 			field, text, _ = strings.Cut(text, " ")
 			text = strings.TrimSpace(text)
 			search.Cursor = field
+
+		case "at":
+			field, text, _ = strings.Cut(text, " ")
+			text = strings.TrimSpace(text)
+			var err error
+			if search.At, err = ParsePointInTime(field); err != nil {
+				search.Err = errors.Join(search.Err, fmt.Errorf("%w: at: %w", ErrSearch, err))
+			}
+
+		case "resolve":
+			search.Resolve = true
+
+		case "inherit":
+			search.Inherit = true
 		}
-		field, text, next = strings.Cut(text, " ")
+		field, text, _ = strings.Cut(text, " ")
 		text = strings.TrimSpace(text)
 	}
 
@@ -176,25 +239,211 @@ func match(n NamespaceSpec, s string) bool {
 	return ok
 }
 
-type ops struct {
-	Left  string
-	Op    string
-	Right string
+// FindOp is a filter on the values of a space. A comparison tests the values
+// named Left against Right, or against Values for in. The ops and, or and not
+// combine the filters in Terms.
+type FindOp struct {
+	Left   string
+	Op     string
+	Right  string
+	Values []string
+	Terms  []FindOp
+}
+
+// ParseFind parses a find expression written in RSQL. The top level terms
+// joined by ; are returned as a list that must all match.
+//
+//	(env==prod,env==staging);owner=like=team-*
+//
+// Comparisons are == != < <= > >= ~ !~ or =eq= =neq= =lt= =le= =gt= =ge=
+// =like= =in= =out=. A bare key matches spaces that have it. ; is and, `,` is
+// or, parentheses group and ! negates. Like patterns use * or % as wildcards.
+// ParseSearch reads a find of only key=op=value or key=value terms and commas
+// in the legacy form where a comma is and. Wrap it in parentheses to read it
+// as RSQL.
+func ParseFind(in string) ([]FindOp, error) {
+	p := rsql.NewParser(rsql.NewLexer(in))
+	program := p.ParseProgram(nil)
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("find: %s", strings.Join(errs, "; "))
+	}
+	if len(program.Statements) != 1 {
+		return nil, fmt.Errorf("find: expected a single expression")
+	}
+	stmt, ok := program.Statements[0].(*rsql.ExpressionStatement)
+	if !ok || stmt.Expression == nil {
+		return nil, fmt.Errorf("find: expected an expression")
+	}
+
+	o, err := findExpr(stmt.Expression)
+	if err != nil {
+		return nil, err
+	}
+	if o.Op == "and" {
+		return o.Terms, nil
+	}
+	return []FindOp{o}, nil
+}
+
+func findExpr(e rsql.Expression) (FindOp, error) {
+	switch e := e.(type) {
+	case *rsql.Identifier:
+		return FindOp{Left: e.Value, Op: "key"}, nil
+
+	case *rsql.PrefixExpression:
+		o, err := findExpr(e.Right)
+		if o.Op == "key" {
+			o.Op = "nkey"
+			return o, err
+		}
+		return FindOp{Op: "not", Terms: []FindOp{o}}, err
+
+	case *rsql.InfixExpression:
+		return findInfix(e)
+	}
+
+	return FindOp{}, fmt.Errorf("find: unexpected %q", e.String())
+}
+
+func findInfix(e *rsql.InfixExpression) (FindOp, error) {
+	switch e.Token.Type {
+	case rsql.TokAND, rsql.TokOR:
+		o := FindOp{Op: "and"}
+		if e.Token.Type == rsql.TokOR {
+			o.Op = "or"
+		}
+		for _, side := range []rsql.Expression{e.Left, e.Right} {
+			t, err := findExpr(side)
+			if err != nil {
+				return o, err
+			}
+			if t.Op == o.Op {
+				o.Terms = append(o.Terms, t.Terms...)
+				continue
+			}
+			o.Terms = append(o.Terms, t)
+		}
+		return o, nil
+	}
+
+	key, ok := e.Left.(*rsql.Identifier)
+	if !ok {
+		return FindOp{}, fmt.Errorf("find: expected key before %s", e.Operator)
+	}
+	o := FindOp{Left: key.Value}
+
+	var negate bool
+	switch e.Token.Type {
+	case rsql.TokEQ:
+		o.Op = "eq"
+	case rsql.TokNEQ:
+		o.Op = "neq"
+	case rsql.TokLT:
+		o.Op = "lt"
+	case rsql.TokLE:
+		o.Op = "le"
+	case rsql.TokGT:
+		o.Op = "gt"
+	case rsql.TokGE:
+		o.Op = "ge"
+	case rsql.TokLIKE:
+		o.Op = "like"
+	case rsql.TokNLIKE:
+		o.Op, negate = "like", true
+	case rsql.TokExtend:
+		switch op := strings.Trim(e.Operator, "="); op {
+		case "like", "in":
+			o.Op = op
+		case "out":
+			o.Op, negate = "in", true
+		default:
+			return o, fmt.Errorf("find: unknown op %s", e.Operator)
+		}
+	default:
+		return o, fmt.Errorf("find: unknown op %s", e.Operator)
+	}
+
+	values := findValues(e.Right)
+	switch {
+	case o.Op == "in":
+		o.Values = values
+	case len(values) == 1:
+		o.Right = values[0]
+	default:
+		return o, fmt.Errorf("find: %s%s expects a single value", o.Left, e.Operator)
+	}
+	if o.Op == "like" {
+		o.Right = strings.ReplaceAll(o.Right, "*", "%")
+	}
+
+	if negate {
+		return FindOp{Op: "not", Terms: []FindOp{o}}, nil
+	}
+	return o, nil
+}
+
+// findValues returns the literal values of a value or list of values.
+func findValues(e rsql.Expression) []string {
+	switch e := e.(type) {
+	case *rsql.Array:
+		var lis []string
+		for _, el := range e.Elements {
+			lis = append(lis, findValues(el)...)
+		}
+		return lis
+	case *rsql.InfixExpression:
+		if e.Token.Type == rsql.TokOR {
+			return append(findValues(e.Left), findValues(e.Right)...)
+		}
+	case *rsql.Identifier:
+		return []string{e.Value}
+	case *rsql.String:
+		return []string{e.Value}
+	case *rsql.Null:
+		return []string{""}
+	case nil:
+		return nil
+	}
+	return []string{e.TokenLiteral()}
+}
+
+// legacyFind returns true if in is the legacy find form of key=op=value or
+// key=value terms separated by commas, where a comma is and. Finds using RSQL
+// grouping, ; or the symbolic ops such as == are read by ParseFind, where a
+// comma is or.
+func legacyFind(in string) bool {
+	if strings.ContainsAny(in, ";()[]!<>~'\"") {
+		return false
+	}
+	for _, item := range strings.Split(in, ",") {
+		eq := strings.Split(item, "=")
+		switch {
+		case len(eq) == 2 && eq[0] != "":
+		case len(eq) == 3 && eq[0] != "" && eq[1] != "":
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-func simpleParse(in string) (out []ops) {
+// simpleParse reads the legacy find form key=op=value separated by commas.
+func simpleParse(in string) (out []FindOp) {
 	items := strings.Split(in, ",")
 	for _, i := range items {
-		log.Println(i)
 		eq := strings.Split(i, "=")
 		switch len(eq) {
 		case 2:
-			out = append(out, ops{eq[0], "eq", eq[1]})
+			out = append(out, FindOp{Left: eq[0], Op: "eq", Right: eq[1]})
 		case 3:
 			if eq[1] == "" {
 				eq[1] = "eq"
 			}
-			out = append(out, ops{eq[0], eq[1], eq[2]})
+			o := FindOp{Left: eq[0], Op: eq[1], Right: eq[2]}
+			if o.Op == "in" {
+				o.Values = strings.Fields(o.Right)
+			}
+			out = append(out, o)
 		}
 	}
 
@@ -1,8 +1,11 @@
 package mercury_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
 	"go.sour.is/pkg/mercury"
@@ -37,7 +40,7 @@ func TestNamespaceParse(t *testing.T) {
 		{
 			getWhere: mkWhere(t, sql.GetWhereSQ),
 			in:       "d42.bgp.kapha.* find active=eq=true",
-			out:      `SELECT * FROM spaces JOIN ( SELECT DISTINCT id FROM mercury_values mv, json_each(mv."values") vs WHERE (json_valid("values") AND name = ? AND vs.value = ?) ) r000 USING (id) WHERE (space LIKE ?)`,
+			out:      `SELECT * FROM spaces JOIN ( SELECT DISTINCT mv.id FROM mercury_values mv, json_each(mv."values") vs WHERE (json_valid("values") AND name = ? AND vs.value = ?) ) r000 USING (id) WHERE (space LIKE ?)`,
 			args:     []any{"active", "true", "d42.bgp.kapha.%"},
 		},
 
@@ -58,8 +61,15 @@ func TestNamespaceParse(t *testing.T) {
 		{
 			getWhere: mkWhere(t, sql.GetWhereSQ),
 			in:       "dn42.* find @type=in=[person,net]",
-			out:      `SELECT `,
-			args:     []any{"d42.bgp.kapha.%"},
+			out:      `SELECT * FROM spaces JOIN ( SELECT DISTINCT mv.id FROM mercury_values mv, json_each(mv."values") vs WHERE (json_valid("values") AND name = ? AND vs.value IN (?,?)) ) r000 USING (id) WHERE (space LIKE ?)`,
+			args:     []any{"@type", "person", "net", "dn42.%"},
+		},
+
+		{
+			getWhere: mkWhere(t, sql.GetWhereSQ),
+			in:       "dn42.* find (env==prod,env==dev);!owner",
+			out:      `SELECT * FROM spaces WHERE ((id IN (SELECT DISTINCT mv.id FROM mercury_values mv, json_each(mv."values") vs WHERE (json_valid("values") AND name = ? AND vs.value = ?)) OR id IN (SELECT DISTINCT mv.id FROM mercury_values mv, json_each(mv."values") vs WHERE (json_valid("values") AND name = ? AND vs.value = ?))) AND id NOT IN (SELECT DISTINCT id FROM mercury_values WHERE name = ?)) AND (space LIKE ?)`,
+			args:     []any{"env", "prod", "env", "dev", "owner", "dn42.%"},
 		},
 	}
 
@@ -78,6 +88,45 @@ func TestNamespaceParse(t *testing.T) {
 	}
 }
 
+func TestParseSearchAt(t *testing.T) {
+	is := is.New(t)
+
+	search := mercury.ParseSearch("app.* at 42")
+	is.NoErr(search.Err)
+	is.Equal(search.At.Revision, uint64(42))
+
+	search = mercury.ParseSearch("app.* at 2024-01-02T03:04:05Z")
+	is.NoErr(search.Err)
+	is.Equal(search.At.Time, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	// a typo is an error rather than a read of the current config.
+	search = mercury.ParseSearch("app.* at 2024-13-01")
+	is.True(errors.Is(search.Err, mercury.ErrSearch))
+	is.Equal(search.Err.Error(), `invalid search: at: "2024-13-01" is not a revision or RFC3339 timestamp`)
+
+	_, err := mercury.Registry.GetConfig(context.Background(), search)
+	is.True(errors.Is(err, mercury.ErrSearch))
+}
+
+func TestParseSearchFind(t *testing.T) {
+	is := is.New(t)
+
+	// == is RSQL where a comma is or.
+	search := mercury.ParseSearch("app.* find env==prod,env==staging")
+	is.NoErr(search.Err)
+	is.Equal(len(search.Find), 1)
+	is.Equal(search.Find[0].Op, "or")
+
+	// the legacy key=op=value form reads a comma as and.
+	search = mercury.ParseSearch("app.* find env=eq=prod,port=eq=80")
+	is.NoErr(search.Err)
+	is.Equal(len(search.Find), 2)
+
+	search = mercury.ParseSearch("app.* find (env==prod")
+	is.True(errors.Is(search.Err, mercury.ErrSearch))
+	is.Equal(search.Find, nil)
+}
+
 func getWhere(search mercury.Search) sq.Sqlizer {
 	var where sq.Or
 	space := "column"
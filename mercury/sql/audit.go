@@ -0,0 +1,116 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/mercury"
+)
+
+var (
+	_ mercury.WriteAudit = (*sqlHandler)(nil)
+	_ mercury.ReadAudit  = (*sqlHandler)(nil)
+)
+
+// WriteAudit records the audit entries in the mercury_audit table.
+func (p *sqlHandler) WriteAudit(ctx context.Context, lis ...mercury.Audit) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	if len(lis) == 0 {
+		return nil
+	}
+
+	insert := sq.Insert("mercury_audit").
+		PlaceholderFormat(p.paceholderFormat).
+		Columns(`"created"`, `"identity"`, `"session"`, `"action"`, `"spaces"`, `"outcome"`, `"hash"`)
+	for _, a := range lis {
+		insert = insert.Values(
+			a.Time.UnixMilli(),
+			a.Identity,
+			a.Session,
+			a.Action,
+			listValue(a.Spaces, p.listFormat),
+			a.Outcome,
+			a.Hash,
+		)
+	}
+
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(insert.ToSql()))
+	_, err := insert.RunWith(p.db).ExecContext(ctx)
+	span.RecordError(err)
+
+	return err
+}
+
+// ReadAudit reads the audit entries matching search newest first. Spaces are
+// matched after reading as they are stored as a list.
+func (p *sqlHandler) ReadAudit(ctx context.Context, search mercury.AuditSearch) ([]mercury.Audit, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	query := sq.Select(`"created"`, `"identity"`, `"session"`, `"action"`, `"spaces"`, `"outcome"`, `"hash"`).
+		From("mercury_audit").
+		OrderBy("created desc", "id desc").
+		PlaceholderFormat(p.paceholderFormat)
+	if search.Identity != "" {
+		query = query.Where(sq.Eq{"identity": search.Identity})
+	}
+	if search.Action != "" {
+		query = query.Where(sq.Eq{"action": search.Action})
+	}
+	if !search.Since.IsZero() {
+		query = query.Where(sq.GtOrEq{"created": search.Since.UnixMilli()})
+	}
+	if !search.Until.IsZero() {
+		query = query.Where(sq.Lt{"created": search.Until.UnixMilli()})
+	}
+	if search.Limit > 0 && len(search.Spaces) == 0 {
+		query = query.Limit(uint64(search.Limit))
+	}
+
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(query.ToSql()))
+	rows, err := query.RunWith(p.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lis []mercury.Audit
+	for rows.Next() {
+		var a mercury.Audit
+		var created int64
+		err = rows.Scan(
+			&created,
+			&a.Identity,
+			&a.Session,
+			&a.Action,
+			listScan(&a.Spaces, p.listFormat),
+			&a.Outcome,
+			&a.Hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.Time = time.UnixMilli(created).UTC()
+
+		if !search.Match(a) {
+			continue
+		}
+		lis = append(lis, a)
+		if search.Limit > 0 && len(lis) >= search.Limit {
+			break
+		}
+	}
+
+	err = rows.Err()
+	span.RecordError(err)
+
+	span.AddEvent(fmt.Sprint("read audit ", len(lis)))
+	return lis, err
+}
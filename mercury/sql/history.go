@@ -0,0 +1,165 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.sour.is/pkg/ident"
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/mercury"
+)
+
+// writeHistory records a revision for each space in config. Spaces that have
+// no history yet get their prior content recorded first so it is not lost.
+// When and by whom that content was written is not known, so it is recorded
+// at the epoch with no author and reads at any earlier time find it.
+func (p *sqlHandler) writeHistory(ctx context.Context, tx sq.BaseRunner, prior, config mercury.Config) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	if len(config) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(config))
+	for i, s := range config {
+		names[i] = s.Space
+	}
+
+	query := sq.Select(`DISTINCT "space"`).
+		From("mercury_history").
+		Where(sq.Eq{"space": names}).
+		PlaceholderFormat(p.paceholderFormat)
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(query.ToSql()))
+	rows, err := query.RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tracked := make(map[string]struct{}, len(names))
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		tracked[name] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	author := ident.FromContext(ctx).Identity()
+	created := time.Now().UnixMilli()
+
+	insert := sq.Insert("mercury_history").
+		PlaceholderFormat(p.paceholderFormat).
+		Columns(`"space"`, `"author"`, `"created"`, `"content"`)
+
+	for _, s := range prior {
+		if _, ok := tracked[s.Space]; ok {
+			continue
+		}
+		insert = insert.Values(s.Space, "", 0, mercury.Config{s}.String())
+	}
+	for _, s := range config {
+		content := ""
+		if len(s.Tags) > 0 || len(s.Notes) > 0 || len(s.List) > 0 {
+			content = mercury.Config{s}.String()
+		}
+		insert = insert.Values(s.Space, author, created, content)
+	}
+
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(insert.ToSql()))
+	_, err = insert.RunWith(tx).ExecContext(ctx)
+
+	return err
+}
+
+// getHistory reads the spaces matching search as they were at search.At.
+// Spaces with no history have not been written since it was first recorded
+// and are read as they are now.
+func (p *sqlHandler) getHistory(ctx context.Context, search mercury.Search) (mercury.Config, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	at := sq.Select(`MAX("revision")`).
+		From("mercury_history").
+		GroupBy(`"space"`)
+	switch {
+	case search.At.Revision > 0:
+		// prior content is recorded at the epoch when a space is first
+		// written, so it is present at every revision before that write.
+		at = at.Where(sq.Or{sq.LtOrEq{"revision": search.At.Revision}, sq.Eq{"created": 0}})
+	default:
+		at = at.Where(sq.LtOrEq{"created": search.At.Time.UnixMilli()})
+	}
+	atSQL, atArgs, err := at.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	query := sq.Select(`"space"`, `"content"`).
+		From("mercury_history").
+		Where(`"revision" IN (`+atSQL+`)`, atArgs...).
+		Where(getNamespaceWhere(search)).
+		OrderBy("space asc").
+		PlaceholderFormat(p.paceholderFormat)
+
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(query.ToSql()))
+	rows, err := query.RunWith(p.db).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var config mercury.Config
+	for rows.Next() {
+		var space, content string
+		if err = rows.Scan(&space, &content); err != nil {
+			return nil, err
+		}
+		if content == "" {
+			continue // space was deleted at this revision.
+		}
+
+		m, err := mercury.ParseText(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := m.Space(space); ok {
+			config = append(config, s)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	untracked, err := p.listSpace(ctx, nil, func(qry sq.SelectBuilder) sq.SelectBuilder {
+		return qry.
+			Where(getNamespaceWhere(search)).
+			Where(`"space" NOT IN (SELECT "space" FROM mercury_history)`)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(untracked) > 0 {
+		current, err := p.listValues(ctx, nil, untracked, nil)
+		if err != nil {
+			return nil, err
+		}
+		config = append(config, current...)
+	}
+
+	span.AddEvent(fmt.Sprint("read history ", len(config)))
+
+	// paging and find are applied after the untracked spaces are merged in.
+	return search.Filter(config), nil
+}
@@ -27,6 +27,57 @@ CREATE INDEX IF NOT EXISTS mercury_values_name_index
     ON mercury_values USING btree
     (name ASC NULLS LAST);
 
+CREATE SEQUENCE IF NOT EXISTS mercury_history_revision_seq;
+
+CREATE TABLE IF NOT EXISTS mercury_history
+(
+    revision integer NOT NULL DEFAULT nextval('mercury_history_revision_seq'::regclass),
+    space character varying NOT NULL,
+    author character varying NOT NULL DEFAULT '',
+    created bigint NOT NULL,
+    content text NOT NULL DEFAULT '',
+    CONSTRAINT mercury_history_pk PRIMARY KEY (revision)
+);
+CREATE INDEX IF NOT EXISTS mercury_history_space_index
+    ON mercury_history USING btree
+    (space ASC NULLS LAST, revision ASC NULLS LAST);
+
+CREATE SEQUENCE IF NOT EXISTS mercury_audit_id_seq;
+
+CREATE TABLE IF NOT EXISTS mercury_audit
+(
+    id integer NOT NULL DEFAULT nextval('mercury_audit_id_seq'::regclass),
+    created bigint NOT NULL,
+    identity character varying NOT NULL DEFAULT '',
+    session character varying NOT NULL DEFAULT '',
+    action character varying NOT NULL,
+    spaces character varying[] NOT NULL DEFAULT '{}'::character varying[],
+    outcome character varying NOT NULL,
+    hash character varying NOT NULL DEFAULT '',
+    CONSTRAINT mercury_audit_pk PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS mercury_audit_created_index
+    ON mercury_audit USING btree
+    (created DESC NULLS LAST);
+
+CREATE SEQUENCE IF NOT EXISTS mercury_outbox_id_seq;
+
+CREATE TABLE IF NOT EXISTS mercury_outbox
+(
+    id integer NOT NULL DEFAULT nextval('mercury_outbox_id_seq'::regclass),
+    created bigint NOT NULL,
+    next bigint NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    dead boolean NOT NULL DEFAULT false,
+    error text NOT NULL DEFAULT '',
+    sent character varying[] NOT NULL DEFAULT '{}'::character varying[],
+    notify json NOT NULL,
+    CONSTRAINT mercury_outbox_pk PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS mercury_outbox_next_index
+    ON mercury_outbox USING btree
+    (dead, next ASC NULLS LAST);
+
 CREATE OR REPLACE VIEW mercury_registry_vw
  AS
  SELECT 
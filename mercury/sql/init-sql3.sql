@@ -18,6 +18,45 @@ CREATE TABLE IF NOT EXISTS mercury_values
     CONSTRAINT mercury_values_pk PRIMARY KEY (id, seq)
 );
 
+CREATE TABLE IF NOT EXISTS mercury_history
+(
+    revision integer NOT NULL CONSTRAINT mercury_history_pk PRIMARY KEY autoincrement,
+    space character varying NOT NULL,
+    author character varying NOT NULL DEFAULT '',
+    created integer NOT NULL,
+    content text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS mercury_history_space_index
+    ON mercury_history (space, revision);
+
+CREATE TABLE IF NOT EXISTS mercury_audit
+(
+    id integer NOT NULL CONSTRAINT mercury_audit_pk PRIMARY KEY autoincrement,
+    created integer NOT NULL,
+    identity character varying NOT NULL DEFAULT '',
+    session character varying NOT NULL DEFAULT '',
+    action character varying NOT NULL,
+    spaces json NOT NULL DEFAULT '[]',
+    outcome character varying NOT NULL,
+    hash character varying NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS mercury_audit_created_index
+    ON mercury_audit (created);
+
+CREATE TABLE IF NOT EXISTS mercury_outbox
+(
+    id integer NOT NULL CONSTRAINT mercury_outbox_pk PRIMARY KEY autoincrement,
+    created integer NOT NULL,
+    next integer NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    dead integer NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT '',
+    sent json NOT NULL DEFAULT '[]',
+    notify json NOT NULL
+);
+CREATE INDEX IF NOT EXISTS mercury_outbox_next_index
+    ON mercury_outbox (dead, next);
+
 drop view if exists mercury_registry_vw;
 CREATE VIEW if not exists mercury_registry_vw
  AS
@@ -0,0 +1,191 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.sour.is/pkg/lg"
+	"go.sour.is/pkg/mercury"
+)
+
+var (
+	_ mercury.Outbox            = (*sqlHandler)(nil)
+	_ mercury.WriteConfigNotify = (*sqlHandler)(nil)
+)
+
+// QueueNotify adds notifies to the mercury_outbox table.
+func (p *sqlHandler) QueueNotify(ctx context.Context, notify ...mercury.Notify) error {
+	return p.queueNotify(ctx, p.db, time.Now(), notify...)
+}
+
+func (p *sqlHandler) queueNotify(ctx context.Context, tx sq.BaseRunner, now time.Time, notify ...mercury.Notify) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	if len(notify) == 0 {
+		return nil
+	}
+
+	insert := sq.Insert("mercury_outbox").
+		PlaceholderFormat(p.paceholderFormat).
+		Columns(`"created"`, `"next"`, `"notify"`)
+	for _, n := range notify {
+		b, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		insert = insert.Values(now.UnixMilli(), now.UnixMilli(), string(b))
+	}
+
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(insert.ToSql()))
+	_, err := insert.RunWith(tx).ExecContext(ctx)
+	span.RecordError(err)
+
+	return err
+}
+
+// DueOutbox returns the notifies due at now and holds them for lease.
+func (p *sqlHandler) DueOutbox(ctx context.Context, now time.Time, limit int, lease time.Duration) (lis []mercury.OutboxItem, err error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	lis, err = p.listOutbox(ctx, tx, func(query sq.SelectBuilder) sq.SelectBuilder {
+		query = query.
+			Where(sq.Eq{"dead": false}).
+			Where(sq.LtOrEq{"next": now.UnixMilli()}).
+			OrderBy("next asc", "id asc")
+		if limit > 0 {
+			query = query.Limit(uint64(limit))
+		}
+		return query
+	})
+	if err != nil || len(lis) == 0 {
+		return lis, err
+	}
+
+	// only keep the notifies no other worker claimed since they were read.
+	claimed := lis[:0]
+	for _, item := range lis {
+		update := sq.Update("mercury_outbox").
+			Set("next", now.Add(lease).UnixMilli()).
+			Where(sq.Eq{"id": item.ID}).
+			Where(sq.LtOrEq{"next": now.UnixMilli()}).
+			PlaceholderFormat(p.paceholderFormat)
+		span.AddEvent(p.name)
+		span.AddEvent(lg.LogQuery(update.ToSql()))
+		var res sql.Result
+		res, err = update.RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			claimed = append(claimed, item)
+		}
+	}
+	lis = claimed
+
+	err = tx.Commit()
+	tx = nil
+
+	return lis, err
+}
+
+// UpdateOutbox stores the outcome of a failed delivery.
+func (p *sqlHandler) UpdateOutbox(ctx context.Context, item mercury.OutboxItem) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	query := sq.Update("mercury_outbox").
+		Set("next", item.Next.UnixMilli()).
+		Set("attempts", item.Attempts).
+		Set("dead", item.Dead).
+		Set("error", item.Error).
+		Set("sent", listValue(item.Sent, p.listFormat)).
+		Where(sq.Eq{"id": item.ID}).
+		PlaceholderFormat(p.paceholderFormat)
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(query.ToSql()))
+	_, err := query.RunWith(p.db).ExecContext(ctx)
+	span.RecordError(err)
+
+	return err
+}
+
+// DeleteOutbox removes a delivered notify.
+func (p *sqlHandler) DeleteOutbox(ctx context.Context, id uint64) error {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	query := sq.Delete("mercury_outbox").
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(p.paceholderFormat)
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(query.ToSql()))
+	_, err := query.RunWith(p.db).ExecContext(ctx)
+	span.RecordError(err)
+
+	return err
+}
+
+// DeadOutbox returns the notifies that were given up on.
+func (p *sqlHandler) DeadOutbox(ctx context.Context) ([]mercury.OutboxItem, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	return p.listOutbox(ctx, p.db, func(query sq.SelectBuilder) sq.SelectBuilder {
+		return query.Where(sq.Eq{"dead": true}).OrderBy("id asc")
+	})
+}
+
+func (p *sqlHandler) listOutbox(ctx context.Context, tx sq.BaseRunner, where func(sq.SelectBuilder) sq.SelectBuilder) ([]mercury.OutboxItem, error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	query := where(sq.Select(`"id"`, `"created"`, `"next"`, `"attempts"`, `"dead"`, `"error"`, `"sent"`, `"notify"`).
+		From("mercury_outbox").
+		PlaceholderFormat(p.paceholderFormat))
+	span.AddEvent(p.name)
+	span.AddEvent(lg.LogQuery(query.ToSql()))
+	rows, err := query.RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lis []mercury.OutboxItem
+	for rows.Next() {
+		var item mercury.OutboxItem
+		var created, next int64
+		var notify string
+		err = rows.Scan(&item.ID, &created, &next, &item.Attempts, &item.Dead, &item.Error, listScan(&item.Sent, p.listFormat), &notify)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(notify), &item.Notify); err != nil {
+			return nil, fmt.Errorf("outbox %d: %w", item.ID, err)
+		}
+		item.Created = time.UnixMilli(created).UTC()
+		item.Next = time.UnixMilli(next).UTC()
+		lis = append(lis, item)
+	}
+
+	err = rows.Err()
+	span.RecordError(err)
+
+	return lis, err
+}
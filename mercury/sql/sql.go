@@ -5,9 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"go.sour.is/pkg/lg"
@@ -27,10 +30,13 @@ type sqlHandler struct {
 }
 
 var (
-	_ mercury.GetIndex    = (*sqlHandler)(nil)
-	_ mercury.GetConfig   = (*sqlHandler)(nil)
-	_ mercury.GetRules    = (*sqlHandler)(nil)
-	_ mercury.WriteConfig = (*sqlHandler)(nil)
+	_ mercury.GetIndex      = (*sqlHandler)(nil)
+	_ mercury.GetConfig     = (*sqlHandler)(nil)
+	_ mercury.GetRules      = (*sqlHandler)(nil)
+	_ mercury.WriteConfig   = (*sqlHandler)(nil)
+	_ mercury.WriteConfigIf = (*sqlHandler)(nil)
+	_ mercury.Paged         = (*sqlHandler)(nil)
+	_ io.Closer             = (*sqlHandler)(nil)
 )
 
 func Register() func(context.Context) error {
@@ -92,6 +98,11 @@ func Register() func(context.Context) error {
 	}
 }
 
+// Close closes the database when the registry replaces the handler.
+func (p *sqlHandler) Close() error {
+	return p.db.Close()
+}
+
 type Space struct {
 	mercury.Space
 	id uint64
@@ -101,10 +112,21 @@ type Value struct {
 	id uint64
 }
 
+// Paged reports that Count and Offset are applied by the query.
+func (p *sqlHandler) Paged() bool { return true }
+
 func (p *sqlHandler) GetIndex(ctx context.Context, search mercury.Search) (mercury.Config, error) {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
+	if search.At != nil {
+		config, err := p.getHistory(ctx, search)
+		for _, s := range config {
+			s.List = nil
+		}
+		return config, err
+	}
+
 	where, err := p.getWhere(search)
 	if err != nil {
 		return nil, err
@@ -127,6 +149,10 @@ func (p *sqlHandler) GetConfig(ctx context.Context, search mercury.Search) (conf
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
+	if search.At != nil {
+		return p.getHistory(ctx, search)
+	}
+
 	where, err := p.getWhere(search)
 	if err != nil {
 		return nil, err
@@ -141,6 +167,19 @@ func (p *sqlHandler) GetConfig(ctx context.Context, search mercury.Search) (conf
 		return nil, nil
 	}
 
+	return p.listValues(ctx, nil, lis, search.Fields)
+}
+
+// listValues reads the values for each of the listed spaces.
+// If fields are given only values with those names are read.
+func (p *sqlHandler) listValues(ctx context.Context, tx sq.BaseRunner, lis []*Space, fields []string) (config mercury.Config, err error) {
+	ctx, span := lg.Span(ctx)
+	defer span.End()
+
+	if tx == nil {
+		tx = p.db
+	}
+
 	spaceIDX := make([]uint64, len(lis))
 	spaceMap := make(map[uint64]int, len(lis))
 	config = make(mercury.Config, len(lis))
@@ -155,10 +194,13 @@ func (p *sqlHandler) GetConfig(ctx context.Context, search mercury.Search) (conf
 		Where(sq.Eq{"id": spaceIDX}).
 		OrderBy("id asc", "seq asc").
 		PlaceholderFormat(p.paceholderFormat)
+	if len(fields) > 0 {
+		query = query.Where(sq.Eq{"name": fields})
+	}
 
 	span.AddEvent(p.name)
 	span.AddEvent(lg.LogQuery(query.ToSql()))
-	rows, err := query.RunWith(p.db).
+	rows, err := query.RunWith(tx).
 		QueryContext(ctx)
 
 	if err != nil {
@@ -244,6 +286,18 @@ func (p *sqlHandler) listSpace(ctx context.Context, tx sq.BaseRunner, where func
 
 // WriteConfig writes a config map to database
 func (p *sqlHandler) WriteConfig(ctx context.Context, config mercury.Config) (err error) {
+	return p.WriteConfigIf(ctx, config, nil)
+}
+
+// WriteConfigIf writes a config map to database if check passes for the
+// stored version of the spaces. The check runs inside the write transaction.
+func (p *sqlHandler) WriteConfigIf(ctx context.Context, config mercury.Config, check func(current mercury.Config) error) (err error) {
+	return p.WriteConfigNotify(ctx, config, check, nil)
+}
+
+// WriteConfigNotify writes a config map to database if check passes and
+// queues the notifies in the outbox in the same transaction.
+func (p *sqlHandler) WriteConfigNotify(ctx context.Context, config mercury.Config, check func(current mercury.Config) error, notify mercury.ListNotify) (err error) {
 	ctx, span := lg.Span(ctx)
 	defer span.End()
 
@@ -281,6 +335,18 @@ func (p *sqlHandler) WriteConfig(ctx context.Context, config mercury.Config) (er
 		return
 	}
 
+	// read current values to record prior content in history
+	prior, err := p.listValues(ctx, tx, lis, nil)
+	if err != nil {
+		return
+	}
+
+	if check != nil {
+		if err = check(prior); err != nil {
+			return
+		}
+	}
+
 	// determine which are being updated
 	var deleteIDs []uint64
 	var updateIDs []uint64
@@ -379,9 +445,23 @@ func (p *sqlHandler) WriteConfig(ctx context.Context, config mercury.Config) (er
 
 	// write all values to db.
 	err = p.writeValues(ctx, tx, newValues)
+	if err != nil {
+		return err
+	}
 	// log.Debugf("WROTE %d ATTRS", len(attrs))
 
-	tx.Commit()
+	// record a revision for each space written.
+	err = p.writeHistory(ctx, tx, prior, config)
+	if err != nil {
+		return err
+	}
+
+	err = p.queueNotify(ctx, tx, time.Now(), notify...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	tx = nil
 
 	return
@@ -451,7 +531,8 @@ func (p *sqlHandler) writeValues(ctx context.Context, tx sq.BaseRunner, lis []*V
 	return
 }
 
-func GetWherePG(search mercury.Search) (func(sq.SelectBuilder) sq.SelectBuilder, error) {
+// getNamespaceWhere builds the space name filter shared by each dialect.
+func getNamespaceWhere(search mercury.Search) sq.Or {
 	var where sq.Or
 	space := "space"
 
@@ -467,40 +548,117 @@ func GetWherePG(search mercury.Search) (func(sq.SelectBuilder) sq.SelectBuilder,
 		}
 	}
 
+	return where
+}
+
+// findLeaf builds the subquery selecting the ids of spaces that have a
+// value satisfying a comparison. It is given the ops key, eq, gt, lt, ge, le,
+// like and in.
+type findLeaf func(o mercury.FindOp) sq.SelectBuilder
+
+// getFindWhere compiles the find ops. Top level comparisons are joined to the
+// query as subqueries on id. Negations and groups using or test id against
+// the same subqueries in the where clause.
+func getFindWhere(find []mercury.FindOp, leaf findLeaf) ([]sq.SelectBuilder, sq.And, error) {
+	var count int
+	var expr func(o mercury.FindOp) sq.Sqlizer
+	expr = func(o mercury.FindOp) sq.Sqlizer {
+		switch o.Op {
+		case "and", "or", "not":
+			var terms []sq.Sqlizer
+			for _, t := range o.Terms {
+				terms = append(terms, expr(t))
+			}
+			switch o.Op {
+			case "or":
+				return sq.Or(terms)
+			case "not":
+				return sq.Expr("NOT (?)", sq.And(terms))
+			}
+			return sq.And(terms)
+
+		case "nkey":
+			count++
+			return sq.Expr("id NOT IN (?)", leaf(mercury.FindOp{Left: o.Left, Op: "key"}))
+
+		case "neq":
+			count += 2
+			return sq.And{
+				sq.Expr("id IN (?)", leaf(mercury.FindOp{Left: o.Left, Op: "key"})),
+				sq.Expr("id NOT IN (?)", leaf(mercury.FindOp{Left: o.Left, Op: "eq", Right: o.Right})),
+			}
+		}
+
+		count++
+		return sq.Expr("id IN (?)", leaf(o))
+	}
+
 	var joins []sq.SelectBuilder
-	for i, o := range search.Find {
-		log.Println(o)
-		if i > MAX_FILTER {
-			err := fmt.Errorf("too many filters [%d]", MAX_FILTER)
-			return nil, err
+	var where sq.And
+	for _, o := range find {
+		switch o.Op {
+		case "key", "eq", "gt", "lt", "ge", "le", "like", "in":
+			count++
+			joins = append(joins, leaf(o))
+		default:
+			where = append(where, expr(o))
 		}
+	}
+
+	if count > MAX_FILTER {
+		return nil, nil, fmt.Errorf("too many filters [%d]", MAX_FILTER)
+	}
+
+	return joins, where, nil
+}
+
+// numeric returns the value as a number if it can be compared as one.
+func numeric(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// pgNumeric matches the values postgres can cast to numeric for comparison.
+const pgNumeric = `^-{0,1}[0-9]+(\.[0-9]+){0,1}$`
+
+func GetWherePG(search mercury.Search) (func(sq.SelectBuilder) sq.SelectBuilder, error) {
+	where := getNamespaceWhere(search)
+
+	compare := map[string]string{"gt": ">", "lt": "<", "ge": ">=", "le": "<="}
+	leaf := func(o mercury.FindOp) sq.SelectBuilder {
 		q := sq.Select("DISTINCT id").From("mercury_values")
 
 		switch o.Op {
 		case "key":
 			q = q.Where(sq.Eq{"name": o.Left})
-		case "nkey":
-			q = q.Where(sq.NotEq{"name": o.Left})
 		case "eq":
 			q = q.Where("name = ? AND ? = any (values)", o.Left, o.Right)
-		case "neq":
-			q = q.Where("name = ? AND ? != any (values)", o.Left, o.Right)
 
-		case "gt":
-			q = q.Where("name = ? AND ? > any (values)", o.Left, o.Right)
-		case "lt":
-			q = q.Where("name = ? AND ? < any (values)", o.Left, o.Right)
-		case "ge":
-			q = q.Where("name = ? AND ? >= any (values)", o.Left, o.Right)
-		case "le":
-			q = q.Where("name = ? AND ? <= any (values)", o.Left, o.Right)
+		case "gt", "lt", "ge", "le":
+			q = q.From(`mercury_values, unnest("values") v`)
+			if f, ok := numeric(o.Right); ok {
+				q = q.Where(
+					"name = ? AND CASE WHEN v ~ '"+pgNumeric+"' THEN v::numeric "+compare[o.Op]+" ? ELSE false END",
+					o.Left, f,
+				)
+				break
+			}
+			q = q.Where("name = ? AND v "+compare[o.Op]+" ?", o.Left, o.Right)
 
-			// case "like":
-			// 	q = q.Where("name = ? AND value LIKE ?", o.Left, o.Right)
-			// case "in":
-			// 	q = q.Where(sq.Eq{"name": o.Left, "value": strings.Split(o.Right, " ")})
+		case "like":
+			q = q.From(`mercury_values, unnest("values") v`).
+				Where("name = ? AND v LIKE ?", o.Left, o.Right)
+		case "in":
+			q = q.From(`mercury_values, unnest("values") v`).
+				Where(sq.Eq{"name": o.Left, "v": o.Values})
 		}
-		joins = append(joins, q)
+
+		return q
+	}
+
+	joins, find, err := getFindWhere(search.Find, leaf)
+	if err != nil {
+		return nil, err
 	}
 
 	return func(s sq.SelectBuilder) sq.SelectBuilder {
@@ -511,69 +669,69 @@ func GetWherePG(search mercury.Search) (func(sq.SelectBuilder) sq.SelectBuilder,
 		if search.Count > 0 {
 			s = s.Limit(search.Count)
 		}
+
+		if search.Offset > 0 {
+			s = s.Offset(search.Offset)
+		}
+
+		if len(find) > 0 {
+			s = s.Where(find)
+		}
+
 		return s.Where(where)
 	}, nil
 }
 
 func GetWhereSQ(search mercury.Search) (func(sq.SelectBuilder) sq.SelectBuilder, error) {
-	var where sq.Or
-
-	var errs error
 	id := "id"
-	space := "space"
 	name := "name"
-	values_each := `json_valid("values")`
+	values_each := `json_each(mv."values")`
 	values_valid := `json_valid("values")`
+	values_numeric := `vs.value <> '' AND vs.value NOT GLOB '*[^0-9.eE+-]*'`
 
-	if errs != nil {
-		return nil, errs
-	}
+	where := getNamespaceWhere(search)
 
-	for _, m := range search.NamespaceSearch {
-		switch m.(type) {
-		case mercury.NamespaceNode:
-			where = append(where, sq.Eq{space: m.Value()})
-		case mercury.NamespaceStar:
-			where = append(where, sq.Like{space: m.Value()})
-		case mercury.NamespaceTrace:
-			e := sq.Expr(`? LIKE `+space+` || '%'`, m.Value())
-			where = append(where, e)
+	leaf := func(o mercury.FindOp) sq.SelectBuilder {
+		if o.Op == "key" {
+			return sq.Select("DISTINCT " + id).From("mercury_values").Where(sq.Eq{name: o.Left})
 		}
-	}
 
-	var joins []sq.SelectBuilder
-	for i, o := range search.Find {
-		log.Println(o)
-		if i > MAX_FILTER {
-			err := fmt.Errorf("too many filters [%d]", MAX_FILTER)
-			return nil, err
+		q := sq.Select("DISTINCT mv." + id).From(`mercury_values mv, ` + values_each + ` vs`)
+
+		value := any(o.Right)
+		column := `vs.value`
+		if f, ok := numeric(o.Right); ok {
+			switch o.Op {
+			case "gt", "lt", "ge", "le":
+				value, column = f, `CAST(vs.value AS REAL)`
+				q = q.Where(values_numeric)
+			}
 		}
-		q := sq.Select("DISTINCT " + id).From(`mercury_values mv, ` + values_each + ` vs`)
 
 		switch o.Op {
-		case "key":
-			q = q.Where(sq.Eq{name: o.Left})
-		case "nkey":
-			q = q.Where(sq.NotEq{name: o.Left})
 		case "eq":
 			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left, `vs.value`: o.Right}})
-		case "neq":
-			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.NotEq{`vs.value`: o.Right}})
 
 		case "gt":
-			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.Gt{`vs.value`: o.Right}})
+			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.Gt{column: value}})
 		case "lt":
-			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.Lt{`vs.value`: o.Right}})
+			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.Lt{column: value}})
 		case "ge":
-			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.GtOrEq{`vs.value`: o.Right}})
+			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.GtOrEq{column: value}})
 		case "le":
-			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.LtOrEq{`vs.value`: o.Right}})
+			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.LtOrEq{column: value}})
 		case "like":
 			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left}, sq.Like{`vs.value`: o.Right}})
 		case "in":
-			q = q.Where(sq.Eq{name: o.Left, "vs.value": strings.Split(o.Right, " ")})
+			q = q.Where(sq.And{sq.Expr(values_valid), sq.Eq{name: o.Left, `vs.value`: o.Values}})
 		}
-		joins = append(joins, q)
+
+		return q
+	}
+
+	joins, find, err := getFindWhere(search.Find, leaf)
+	if err != nil {
+		return nil, err
 	}
 
 	return func(s sq.SelectBuilder) sq.SelectBuilder {
@@ -589,6 +747,10 @@ func GetWhereSQ(search mercury.Search) (func(sq.SelectBuilder) sq.SelectBuilder,
 			s = s.Offset(search.Offset)
 		}
 
+		if len(find) > 0 {
+			s = s.Where(find)
+		}
+
 		return s.Where(where)
 	}, nil
 }
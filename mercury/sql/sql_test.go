@@ -0,0 +1,174 @@
+package sql_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	msql "go.sour.is/pkg/mercury/sql"
+	_ "modernc.org/sqlite"
+)
+
+// testDB configures the registry with a sql source on a new SQLite database.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := "file:" + t.TempDir() + "/mercury.db"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("init-sql3.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	msql.Register()
+	src := mercury.NewSpace("mercury.source.sql.test").AddKeys(
+		mercury.NewValue("match").SetValues("1 *"),
+		mercury.NewValue("dbtype").SetValues("sqlite"),
+		mercury.NewValue("dsn").SetValues(dsn),
+	)
+	if err = mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mercury.Registry.Configure(mercury.SpaceMap{}) })
+
+	return db
+}
+
+func write(t *testing.T, text string) {
+	t.Helper()
+
+	m, err := mercury.ParseText(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mercury.Registry.WriteConfig(context.Background(), m.ToArray()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, search string) string {
+	t.Helper()
+
+	lis, err := mercury.Registry.GetConfig(context.Background(), mercury.ParseSearch(search))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(lis)
+	return lis.EnvString()
+}
+
+func TestHistory(t *testing.T) {
+	is := is.New(t)
+	testDB(t)
+
+	write(t, "@test.a\nfoo :one\n")
+	write(t, "@test.a\nfoo :two\n")
+	write(t, "@test.b\nbar :one\n")
+	write(t, "@test.b\n")
+
+	is.Equal(read(t, "test.*"), "test.a:foo=two\n")
+	is.Equal(read(t, "test.* at 1"), "test.a:foo=one\n")
+	is.Equal(read(t, "test.* at 3"), "test.a:foo=two\ntest.b:bar=one\n")
+	is.Equal(read(t, "test.* at 4"), "test.a:foo=two\n")
+	is.Equal(read(t, "test.* at 2000-01-01T00:00:00Z"), "")
+}
+
+func TestHistoryUntracked(t *testing.T) {
+	is := is.New(t)
+	db := testDB(t)
+
+	// spaces written before history was kept.
+	write(t, "@test.a\nfoo :old\n")
+	write(t, "@test.b\nbar :old\n")
+	_, err := db.Exec(`DELETE FROM mercury_history`)
+	is.NoErr(err)
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	write(t, "@test.a\nfoo :new\n")
+
+	// the prior content is found at every revision and time before the write.
+	is.Equal(read(t, "test.* at 1"), "test.a:foo=old\ntest.b:bar=old\n")
+	is.Equal(read(t, "test.* at "+before.Format(time.RFC3339Nano)), "test.a:foo=old\ntest.b:bar=old\n")
+	is.Equal(read(t, "test.* at "+time.Now().Format(time.RFC3339Nano)), "test.a:foo=new\ntest.b:bar=old\n")
+
+	var revision int
+	is.NoErr(db.QueryRow(`SELECT MAX("revision") FROM mercury_history`).Scan(&revision))
+	is.Equal(read(t, "test.* at "+strconv.Itoa(revision)), "test.a:foo=new\ntest.b:bar=old\n")
+}
+
+func TestFind(t *testing.T) {
+	testDB(t)
+
+	write(t, "@app.one\nenv :prod\nport :80\n")
+	write(t, "@app.two\nenv :staging\nport :8080\n")
+	write(t, "@app.three\nenv :dev\n")
+
+	tests := map[string]string{
+		"app.* find (env==prod,env==staging)": "app.one:env=prod\napp.one:port=80\napp.two:env=staging\napp.two:port=8080\n",
+		"app.* find env==prod,env==staging":   "app.one:env=prod\napp.one:port=80\napp.two:env=staging\napp.two:port=8080\n",
+		"app.* find env==prod,port>1000":      "app.one:env=prod\napp.one:port=80\napp.two:env=staging\napp.two:port=8080\n",
+		"app.* find env!=prod":                "app.three:env=dev\napp.two:env=staging\napp.two:port=8080\n",
+		"app.* find env=eq=prod,port=gt=10":   "app.one:env=prod\napp.one:port=80\n",
+		"app.* find env=eq=prod,port=gt=100":  "",
+		"app.* find env==dev;port==80":        "",
+	}
+	for search, want := range tests {
+		t.Run(search, func(t *testing.T) {
+			is.New(t).Equal(read(t, search), want)
+		})
+	}
+}
+
+func TestWherePG(t *testing.T) {
+	is := is.New(t)
+
+	eq := `SELECT DISTINCT id FROM mercury_values WHERE name = ? AND ? = any (values)`
+	tests := []struct {
+		search string
+		query  string
+		args   []any
+	}{
+		{
+			"app.* find (env==prod,env==staging)",
+			`SELECT id FROM mercury_spaces WHERE ((id IN (` + eq + `) OR id IN (` + eq + `))) AND (space LIKE ?)`,
+			[]any{"env", "prod", "env", "staging", "app.%"},
+		},
+		{
+			"app.* find env!=prod",
+			`SELECT id FROM mercury_spaces WHERE ((id IN (SELECT DISTINCT id FROM mercury_values WHERE name = ?) AND id NOT IN (` + eq + `))) AND (space LIKE ?)`,
+			[]any{"env", "env", "prod", "app.%"},
+		},
+		{
+			"app.* find env=eq=prod,port=gt=80",
+			`SELECT id FROM mercury_spaces JOIN ( ` + eq + ` ) r000 USING (id)` +
+				` JOIN ( SELECT DISTINCT id FROM mercury_values, unnest("values") v WHERE name = ? AND CASE WHEN v ~ '^-{0,1}[0-9]+(\.[0-9]+){0,1}$' THEN v::numeric > ? ELSE false END ) r001 USING (id)` +
+				` WHERE (space LIKE ?)`,
+			[]any{"env", "prod", "port", 80.0, "app.%"},
+		},
+	}
+	for _, tt := range tests {
+		where, err := msql.GetWherePG(mercury.ParseSearch(tt.search))
+		is.NoErr(err)
+		query, args, err := where(sq.Select("id").From("mercury_spaces")).ToSql()
+		is.NoErr(err)
+		is.Equal(query, tt.query)
+		is.Equal(args, tt.args)
+	}
+}
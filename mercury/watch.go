@@ -0,0 +1,71 @@
+package mercury
+
+import (
+	"log"
+	"sync"
+)
+
+// WatchBuffer is how many updates a watcher may fall behind by before it is
+// closed.
+var WatchBuffer = 16
+
+type watcher struct {
+	search Search
+	ch     chan *Space
+	once   sync.Once
+}
+
+func (w *watcher) close() { w.once.Do(func() { close(w.ch) }) }
+
+// watchers a set of subscriptions to written spaces.
+type watchers struct {
+	mu   sync.Mutex
+	subs map[*watcher]struct{}
+}
+
+// Watch subscribes to spaces matching search each time they are written.
+// The returned func must be called to release the subscription. The channel
+// is closed if the subscriber falls behind by more than WatchBuffer updates,
+// so it can read the spaces again and subscribe anew.
+func (r *registry) Watch(search Search) (<-chan *Space, func()) {
+	w := &watcher{search: search, ch: make(chan *Space, WatchBuffer)}
+
+	r.watchers.mu.Lock()
+	if r.watchers.subs == nil {
+		r.watchers.subs = make(map[*watcher]struct{})
+	}
+	r.watchers.subs[w] = struct{}{}
+	r.watchers.mu.Unlock()
+
+	return w.ch, func() {
+		r.watchers.mu.Lock()
+		delete(r.watchers.subs, w)
+		r.watchers.mu.Unlock()
+		w.close()
+	}
+}
+
+// publish sends written spaces to the matching watchers.
+// Slow watchers that have a full buffer are closed.
+func (r *registry) publish(spaces Config) {
+	r.watchers.mu.Lock()
+	defer r.watchers.mu.Unlock()
+
+	for w := range r.watchers.subs {
+		for _, s := range spaces {
+			if !w.search.NamespaceSearch.Match(s.Space) {
+				continue
+			}
+			select {
+			case w.ch <- s:
+				continue
+			default:
+			}
+
+			log.Println("mercury watch: closing watcher that missed", s.Space)
+			delete(r.watchers.subs, w)
+			w.close()
+			break
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package mercury_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.sour.is/pkg/mercury"
+	"go.sour.is/pkg/mercury/mem"
+)
+
+func TestWatch(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	mercury.Registry.Register("test-watch", func(s *mercury.Space) any { return mem.New(nil) })
+	src := mercury.NewSpace("mercury.source.test-watch.default")
+	src.AddKeys(mercury.NewValue("match").SetValues("1 *"))
+	is.NoErr(mercury.Registry.Configure(mercury.SpaceMap{src.Space: src}))
+	defer mercury.Registry.Configure(mercury.SpaceMap{})
+
+	write := func(text string) {
+		m, err := mercury.ParseText(strings.NewReader(text))
+		is.NoErr(err)
+		lis := m.ToArray()
+		sort.Sort(lis)
+		is.NoErr(mercury.Registry.WriteConfig(ctx, lis))
+	}
+	next := func(ch <-chan *mercury.Space) (*mercury.Space, bool) {
+		select {
+		case s, ok := <-ch:
+			return s, ok
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+			return nil, false
+		}
+	}
+
+	updates, cancel := mercury.Registry.Watch(mercury.ParseSearch("app.*"))
+	defer cancel()
+
+	// only the spaces matching the search are sent.
+	write("@other\nhost :db\n\n@app.one\nhost :db\n")
+	s, ok := next(updates)
+	is.True(ok)
+	is.Equal(s.Space, "app.one")
+	is.Equal(len(updates), 0)
+
+	// a watcher that falls behind is closed.
+	defer func(n int) { mercury.WatchBuffer = n }(mercury.WatchBuffer)
+	mercury.WatchBuffer = 1
+	slow, cancelSlow := mercury.Registry.Watch(mercury.ParseSearch("app.*"))
+	defer cancelSlow()
+
+	write("@app.one\nhost :db1\n\n@app.two\nhost :db1\n")
+	s, ok = next(slow)
+	is.True(ok)
+	is.Equal(s.Space, "app.one")
+	_, ok = next(slow)
+	is.True(!ok)
+
+	// other watchers are not affected.
+	s, _ = next(updates)
+	is.Equal(s.Space, "app.one")
+	s, _ = next(updates)
+	is.Equal(s.Space, "app.two")
+}
@@ -85,8 +85,7 @@ func (l *Lexer) NextToken() Token {
 			l.readRune()
 			tok.Type, tok.Literal = TokNLIKE, string(r)+string(l.rune)
 		} else {
-			tok = newToken(TokIllegal, l.rune)
-			return tok
+			tok = newToken(TokNOT, l.rune)
 		}
 	case '<':
 		if l.peekRune() == '=' {
@@ -26,6 +26,9 @@ var precidences = map[TokenType]int{
 	TokLIKE: PrecedenceCompare,
 	TokOR:   PrecedenceOR,
 	TokAND:  PrecedenceAND,
+
+	TokNLIKE:  PrecedenceCompare,
+	TokExtend: PrecedenceCompare,
 }
 
 type (
@@ -59,6 +62,7 @@ func NewParser(l *Lexer) *Parser {
 	p.registerPrefix(TokString, p.parseString)
 	p.registerPrefix(TokLParen, p.parseGroupedExpression)
 	p.registerPrefix(TokLBracket, p.parseArray)
+	p.registerPrefix(TokNOT, p.parsePrefixExpression)
 
 	p.infixParseFns = make(map[TokenType]infixParseFn)
 	p.registerInfix(TokEQ, p.parseInfixExpression)
@@ -68,6 +72,8 @@ func NewParser(l *Lexer) *Parser {
 	p.registerInfix(TokGT, p.parseInfixExpression)
 	p.registerInfix(TokGE, p.parseInfixExpression)
 	p.registerInfix(TokLIKE, p.parseInfixExpression)
+	p.registerInfix(TokNLIKE, p.parseInfixExpression)
+	p.registerInfix(TokExtend, p.parseInfixExpression)
 	p.registerInfix(TokAND, p.parseInfixExpression)
 	p.registerInfix(TokOR, p.parseInfixExpression)
 
@@ -259,6 +265,17 @@ func (p *Parser) parseExpressionList(end TokenType) []Expression {
 
 	return list
 }
+func (p *Parser) parsePrefixExpression() Expression {
+	expression := &PrefixExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+	}
+
+	p.nextToken()
+	expression.Right = p.parseExpression(PrecedenceHighest)
+
+	return expression
+}
 func (p *Parser) parseInfixExpression(left Expression) Expression {
 	expression := &InfixExpression{
 		Token:    p.curToken,
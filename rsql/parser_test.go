@@ -147,6 +147,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			`director=='name\'s';actor=eq="name\'s";Year=le=2000,Year>=2010;one <= -1.0, two != true`,
 			`((((director=="name's");(actor=eq="name's"));((Year=le=2000),(Year>=2010)));((one<=-1.0),(two!=true)))`,
 		},
+		{
+			`!(env==prod,env==staging);owner=like=team-*`,
+			`((!((env==prod),(env==staging)));(owner=like=team-*))`,
+		},
 	}
 	t.Run("Operator Precidence Parsing", func(t *testing.T) {
 		is := is.New(t)